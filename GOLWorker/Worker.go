@@ -1,24 +1,36 @@
 package main
 
 import (
-	"errors"
 	"flag"
-	"fmt"
 	"math/rand"
 	"net"
 	"net/rpc"
 	"os"
+	"os/signal"
+	"runtime"
 	"sync"
+	"syscall"
 	"time"
-	"uk.ac.bris.cs/gameoflife/stubs"
-	"uk.ac.bris.cs/gameoflife/util"
+	"uk.ac.bris.cs/gameoflife/worker"
 )
 
 func main() {
 	pAddr := flag.String("address", "localhost:8031", "Address to listen on")
+	pBroker := flag.String("broker", "", "Address of a Broker to register with, so controllers can use this Worker without being given its address")
+	pCapacity := flag.Int("capacity", 1, "How much of the world this Worker can take compared to others, for a Broker it registers with")
+	pHeartbeat := flag.Duration("heartbeat", 2*time.Second, "How often to let the Broker know this Worker is still there")
+	pSwar := flag.Bool("swar", false, "Calculate 64 cells at a time with bitwise adders on the packed world, rather than a cell at a time")
+	pThreads := flag.Int("threads", runtime.NumCPU(), "Number of goroutines to split this Worker's section of the world between when calculating a turn")
+	pCheckpointFile := flag.String("checkpointFile", "", "File to write this Worker's section of the world to when stopped by a signal, empty to not write one")
+	pLeaveTimeout := flag.Duration("leaveTimeout", 10*time.Second, "How long to wait for the Broker to take this Worker's section of the world when stopped by a signal")
 	flag.Parse()
+	if *pThreads < 1 {
+		println("Error: -threads must be at least 1")
+		return
+	}
 	rand.Seed(time.Now().UnixNano())
-	err := rpc.Register(&Worker{})
+	w := worker.New(worker.Settings{Swar: *pSwar, Threads: *pThreads})
+	err := rpc.Register(w)
 	if err != nil {
 		println("Error registering worker:", err.Error())
 		return
@@ -28,210 +40,58 @@ func main() {
 		println("Error listening on network:", err.Error())
 		return
 	}
-	defer listener.Close()
-	rpc.Accept(listener)
-}
-
-type Worker struct {
-	topHalo       []byte
-	world         [][]byte
-	botHalo       chan []byte
-	worldMu       sync.Mutex
-	worldChan     chan [][]byte
-	turn          int
-	width         int
-	height        int
-	PrintProgress bool
-	worldBuilt    chan bool
-	workerAbove   *rpc.Client
-}
-
-// Init : Called by Broker to first place data inside a worker
-func (w *Worker) Init(req stubs.WorkerInitReq, res *stubs.None) (err error) {
-	println("Worker created.")
-	w.world = req.World
-	w.turn = 0
-	w.width = req.Width
-	w.height = req.Height
-	w.worldChan = make(chan [][]byte, 1)
-	w.worldBuilt = make(chan bool, 1)
-	w.topHalo = []byte{}
-	w.botHalo = make(chan []byte, 1)
-	w.PrintProgress = req.PrintProgress
-	if w.PrintProgress {
-		println("On Turn", w.turn)
-		util.VisualiseMatrix(w.world, w.width, w.height)
-	}
-	return
-}
-
-// Start : Called by Broker once to start communication between workers
-func (w *Worker) Start(req stubs.WorkerStartReq, res *stubs.None) (err error) {
-	//Connect with worker above
-	w.worldBuilt <- true
-	w.workerAbove, err = rpc.Dial("tcp", req.AboveAdr)
-	if err != nil {
-		println("Error in Worker connecting to Worker: ", err.Error())
-		return errors.New(fmt.Sprint("Error in Worker connecting to Worker: ", err.Error()))
-	}
-	//Do first communication with neighbouring workers
-	progressHelper(w)
-	return
-}
-
-// Progress : Called by Broker to progressHelper the worker one turn
-func (w *Worker) Progress(req stubs.None, res *stubs.Turn) (err error) {
-	//Get world when done calculating
-	w.worldMu.Lock()
-	w.world = <-w.worldChan
-	w.turn++
-	w.worldMu.Unlock()
-	w.worldBuilt <- true
-
-	//Send receive neighbours halo/send world to calculate
-	err = progressHelper(w)
-	if err != nil {
-		return err
-	}
-	res.Turn = w.turn
-	return
-}
-
-// progressHelper : helper command
-func progressHelper(w *Worker) (err error) {
-	//Share+Get halo region w neighbour above
-	topHaloRes := stubs.WorkerHaloReqRes{}
-	err = w.workerAbove.Call(stubs.WorkerHalo, stubs.WorkerHaloReqRes{Halo: w.world[0]}, &topHaloRes)
-	if err != nil {
-		println("Error doing Halo exchange", err.Error())
-		return errors.New(fmt.Sprint("Error in Worker calling Halo on Worker: ", err.Error()))
-	}
-
-	//Ensure we have received halo region from neighbour below
-	topHalo := topHaloRes.Halo
-	botHalo := <-w.botHalo
-
-	//Start calculating first turn
-	go calculateNextState(w.world, topHalo, botHalo, w.worldChan, w.width, w.height, w.turn, w.PrintProgress)
-	return
+	if *pBroker != "" {
+		go worker.RegisterWithBroker(*pBroker, *pAddr, *pCapacity, *pHeartbeat, worker.Killed(w))
+	}
+	var conns sync.WaitGroup
+	go serve(listener, &conns)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	status := 0
+	select {
+	case <-worker.Killed(w):
+		_ = listener.Close()
+		//The Broker hangs up once it has the reply to Kill, neighbours once they have exited too
+		waitForConns(&conns, 5*time.Second)
+	case sig := <-signals:
+		go func() {
+			<-signals
+			println("Worker signalled again, exiting now.")
+			os.Exit(130)
+		}()
+		println("Worker stopping on", sig.String())
+		status = worker.Leave(w, *pBroker, *pAddr, *pCheckpointFile, *pLeaveTimeout)
+		_ = listener.Close()
+	}
+	println("Worker exited.")
+	os.Exit(status)
 }
 
-// Halo : Called by below neighbour Worker to exchange halo regions.
-// each worker should call this on their neighbour above and have it called on them by there neighbour below
-func (w *Worker) Halo(req stubs.WorkerHaloReqRes, res *stubs.WorkerHaloReqRes) (err error) {
-	<-w.worldBuilt
-	//Receive top from Worker below
-	w.botHalo <- req.Halo
-	//Send bottom of this worker to Worker below
-	w.worldMu.Lock()
-	res.Halo = make([]byte, w.width)
-	copy(res.Halo, w.world[w.height-1])
-	w.worldMu.Unlock()
-	return
-}
-
-// Count : Called by Broker to count alive cells in worker
-func (w *Worker) Count(req stubs.None, res *stubs.CountCellRes) (err error) {
-	w.worldMu.Lock()
-	cells := 0
-	for y := 0; y < w.height; y++ {
-		for x := 0; x < w.width; x++ {
-			if w.world[y][x] == 255 {
-				cells++
-			}
+// serve : serves RPCs on every connection accepted until the listener is closed, counting the ones still open in conns
+func serve(listener net.Listener, conns *sync.WaitGroup) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
 		}
+		conns.Add(1)
+		go func() {
+			rpc.ServeConn(conn)
+			conns.Done()
+		}()
 	}
-	res.Count = cells
-	res.Turn = w.turn
-	w.worldMu.Unlock()
-	return
-}
-
-// Fetch : Called by Broker to get the world stored in worker
-func (w *Worker) Fetch(req stubs.None, res *stubs.WorldRes) (err error) {
-	res.World = w.world
-	res.Turn = w.turn
-	return
-}
-
-func (w *Worker) Quit(req stubs.None, res *stubs.None) (err error) {
-	println("Worker quit.")
-	w.turn = -1
-	return
 }
 
-func (w *Worker) Kill(req stubs.None, res *stubs.None) (err error) {
-	println("Worker killed.")
-	os.Exit(0)
-	return
-}
-
-//using indexing x,y where 0,0 is top left of board
-func calculateNextState(world [][]byte, topPad, botPad []byte, worldChan chan<- [][]byte, width, height, turn int, printProgress bool) {
-	if printProgress {
-		println("On Turn", turn)
-		println("toppad:")
-		var padBox [][]byte
-		padBox = append(padBox, topPad)
-		println("top pad:")
-		util.VisualiseMatrix(padBox, width, 1)
-		util.VisualiseMatrix(world, width, height)
-		var padBox2 [][]byte
-		padBox2 = append(padBox2, botPad)
-		println("botpad")
-		util.VisualiseMatrix(padBox2, width, 1)
-	}
-
-	var oldWorld [][]byte
-	oldWorld = append(oldWorld, topPad)
-	oldWorld = append(oldWorld, world...)
-	oldWorld = append(oldWorld, botPad)
-	newWorld := make([][]byte, height)
-	for y := 1; y < height+1; y++ {
-		newWorld[y-1] = make([]byte, width)
-		for x := 0; x < width; x++ {
-			count := liveNeighbourCount(y, x, width, oldWorld)
-			if oldWorld[y][x] == 255 { //if cells alive:-
-				if count == 2 || count == 3 { //any live cell with two or three live neighbours is unaffected
-					newWorld[y-1][x] = 255
-				}
-				//any live cell with fewer than two or more than three live neighbours dies
-				//in go slices are initialized to zero, so we don't need to do anything
-			} else { //cells dead
-				if count == 3 { //any dead cell with exactly three live neighbours becomes alive
-					newWorld[y-1][x] = 255
-				}
-			}
-		}
-	}
-	worldChan <- newWorld
-}
-
-func liveNeighbourCount(y, x, w int, world [][]byte) int8 {
-	var count int8 = 0
-	if world[y+1][(x+1+w)%w] == 255 {
-		count++
-	}
-	if world[y+1][x] == 255 {
-		count++
-	}
-	if world[y+1][(x-1+w)%w] == 255 {
-		count++
-	}
-	if world[y][(x+1+w)%w] == 255 {
-		count++
-	}
-	if world[y][(x-1+w)%w] == 255 {
-		count++
-	}
-	if world[y-1][(x+1+w)%w] == 255 {
-		count++
-	}
-	if world[y-1][x] == 255 {
-		count++
-	}
-	if world[y-1][(x-1+w)%w] == 255 {
-		count++
+// waitForConns : waits for every connection to be closed by the other end, giving up after timeout
+func waitForConns(conns *sync.WaitGroup, timeout time.Duration) {
+	closed := make(chan struct{})
+	go func() {
+		conns.Wait()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(timeout):
 	}
-	return count
 }
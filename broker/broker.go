@@ -0,0 +1,2345 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"uk.ac.bris.cs/gameoflife/checkpoint"
+	"uk.ac.bris.cs/gameoflife/hashlife"
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// Settings : what a Broker is started with, the same for every session it runs
+type Settings struct {
+	SnapshotInterval int           //turns between snapshots of the world kept to recover from a Worker crashing
+	WorkerTimeout    time.Duration //how long a Worker may go without responding before it is treated as dead
+	CheckpointFile   string        //file checkpoints are written to and resumed from
+	CheckpointTurns  int           //turns between checkpoints, 0 to disable
+	CheckpointEvery  time.Duration //longest time between checkpoints, 0 to disable
+	StreamBuffer     int           //turns of flipped cells held for a controller that is behind
+	StreamWait       time.Duration //how long to hold up the workers for a controller that is behind before skipping to a keyframe
+	BalanceEvery     int           //turns between moving rows from slower Workers to faster ones, 0 to disable
+	HeartbeatTimeout time.Duration //how long a registered Worker may go without a heartbeat before it is forgotten
+	Autonomous       bool          //let Workers run turns by themselves between snapshots
+
+	//Dial connects to the Worker at an address, giving up after the timeout if there is one.
+	//Nil dials over TCP, in process it is whatever the Workers are being served over
+	Dial func(address string, timeout time.Duration) (net.Conn, error)
+}
+
+// Defaults : the settings a Broker is started with unless told otherwise
+var Defaults = Settings{
+	SnapshotInterval: 100,
+	WorkerTimeout:    10 * time.Second,
+	CheckpointFile:   "out/checkpoint.pgm",
+	StreamBuffer:     256,
+	StreamWait:       5 * time.Second,
+	BalanceEvery:     100,
+	HeartbeatTimeout: 6 * time.Second,
+}
+
+// New : a Broker with no sessions or registered Workers yet, ready to be registered with an rpc.Server
+func New(settings Settings) *Broker {
+	return &Broker{
+		snapshotInterval: settings.SnapshotInterval,
+		workerTimeout:    settings.WorkerTimeout,
+		checkpointFile:   settings.CheckpointFile,
+		checkpointTurns:  settings.CheckpointTurns,
+		checkpointEvery:  settings.CheckpointEvery,
+		streamBuffer:     settings.StreamBuffer,
+		streamWait:       settings.StreamWait,
+		balanceEvery:     settings.BalanceEvery,
+		autonomous:       settings.Autonomous,
+		dial:             settings.Dial,
+		registry:         make(map[string]*registration),
+		heartbeatTimeout: settings.HeartbeatTimeout,
+		sessions:         make(map[string]*session),
+		claims:           make(map[string]string),
+		killed:           make(chan struct{}),
+	}
+}
+
+// Killed : closed once Kill has shut the cluster down, so whatever is serving the Broker can stop
+func Killed(b *Broker) <-chan struct{} {
+	return b.killed
+}
+
+// ShutDown : stops every session's world running after the turn it is on, telling its controller why,
+// then writes a last checkpoint of each if checkpointing. Returns the status to exit with, 1 if a checkpoint couldn't be written
+func ShutDown(b *Broker, sig os.Signal) int {
+	println("Broker stopping on", sig.String())
+	status := 0
+	for _, s := range allSessions(b) {
+		s.runMu.Lock()
+		isRunning := s.isRunning
+		s.stopReason = errors.New(fmt.Sprint("Broker stopped by ", sig.String()))
+		s.runMu.Unlock()
+		if !isRunning {
+			continue
+		}
+		stopRun(s)
+
+		if s.checkpointTurns == 0 && s.checkpointEvery == 0 {
+			continue
+		}
+		err := takeSnapshot(s)
+		if err == nil {
+			err = saveCheckpoint(s)
+		}
+		if err != nil {
+			println("Error in Broker saving checkpoint of session", s.id, "before exiting:", err.Error())
+			status = 1
+		}
+	}
+	return status
+}
+
+// Broker : what every session shares, the settings it was started with, the Workers registered with it
+// and which session each Worker in use is running a world for
+type Broker struct {
+	snapshotInterval int
+	workerTimeout    time.Duration
+	checkpointFile   string //each session other than the default one checkpoints to a file named after it next to this
+	checkpointTurns  int
+	checkpointEvery  time.Duration
+	streamBuffer     int
+	streamWait       time.Duration
+	balanceEvery     int
+	autonomous       bool //workers run by themselves with RunUntil, rather than one Progress call per turn
+	dial             func(address string, timeout time.Duration) (net.Conn, error)
+
+	registry         map[string]*registration //Workers that registered themselves, by address
+	heartbeatTimeout time.Duration
+	registryMu       sync.Mutex
+
+	sessions   map[string]*session //worlds being run, by session ID
+	claims     map[string]string   //session each Worker in use is running a world for, by address
+	sessionsMu sync.Mutex
+
+	killed   chan struct{} //closed once Kill has shut the cluster down, so the Broker exits
+	killOnce sync.Once
+}
+
+// session : a world run by the Broker for controllers using the same session ID, on Workers no other session is using
+type session struct {
+	*Broker
+	id string
+
+	world       util.PackedWorld //last consistent world, used to restart workers if one crashes
+	worldTurn   int              //turn that world is from
+	currentTurn int
+	finalTurn   int
+	width       int
+	height      int
+	rule        util.Rule
+	boundary    util.Boundary
+
+	printProgress bool
+
+	workers        []*rpc.Client
+	workersAdr     []string
+	workerCapacity []int
+	workerSections []int //row each row of tiles starts on, with the height on the end
+	workerColumns  []int //column each column of tiles starts on, with the width on the end
+	workerCount    int
+	bandCount      int  //bands of rows asked for when more than the workers, each worker then calculates several at once
+	tiled          bool //split the world into a grid of tiles rather than bands of rows
+	gridRows       int
+	gridCols       int             //worker i holds the tile in row i/gridCols and column i%gridCols of the grid
+	haloDepth      int             //rows and columns of halo workers swap at a time
+	workerDepth    int             //haloDepth cut down to fit the smallest tile
+	workerBusy     []time.Duration //time each worker has spent calculating since the last rebalance
+	epoch          int             //incremented every time the world is (re)distributed to workers
+
+	lastCheckpoint time.Time
+
+	state        runState
+	stepUntil    int  //turn a stepping world pauses again on
+	parked       bool //the loop is waiting between turns for a paused world to carry on
+	stateMu      sync.Mutex
+	stateChanged *sync.Cond //broadcast whenever state or parked changes
+	progressMu   sync.Mutex
+
+	flips       chan stubs.TurnDiff //turns of flipped cells waiting to be sent to the controller
+	isStreaming bool                //whether a controller has subscribed to flips
+	resync      bool                //controller fell behind and needs a keyframe before any more flips
+	streamDone  chan struct{}       //closed once the last flips of this world are queued
+	streamMu    sync.Mutex
+
+	isRunning  bool          //whether a ProgressAll loop is currently running
+	runDone    chan struct{} //closed when the running ProgressAll loop finishes
+	runErr     error         //what the last ProgressAll loop finished with
+	stopReason error         //why the Broker stopped the loop itself, given to the controller in place of finishing
+	runMu      sync.Mutex
+
+	holds  chan hold   //asks an autonomous loop to stop every worker on the same turn
+	unhold func()      //releases the hold taken by Pause, nil when there isn't one
+	scales chan *scale //workers waiting to be added or removed by the running loop
+
+	hashLife     *hashlife.Universe //runs the world on the Broker instead of on workers when set
+	hashLifeStep int                //hashLife jumps up to 2^hashLifeStep turns at a time
+}
+
+// defaultSession : the session of controllers that don't give one
+const defaultSession = "default"
+
+// newSession : a session with nothing loaded yet
+func newSession(b *Broker, id string) *session {
+	s := &session{
+		Broker:     b,
+		id:         id,
+		flips:      make(chan stubs.TurnDiff, b.streamBuffer),
+		streamDone: make(chan struct{}),
+		holds:      make(chan hold),
+		scales:     make(chan *scale, 1),
+	}
+	s.stateChanged = sync.NewCond(&s.stateMu)
+	return s
+}
+
+// findSession : the session with id, for calls on a world that has already been loaded by Init or Restore
+func findSession(b *Broker, id string) (*session, error) {
+	if id == "" {
+		id = defaultSession
+	}
+	b.sessionsMu.Lock()
+	defer b.sessionsMu.Unlock()
+	s, ok := b.sessions[id]
+	if !ok {
+		return nil, errors.New(fmt.Sprint("no session ", id, " on Broker"))
+	}
+	return s, nil
+}
+
+// openSession : the session with id, started if there isn't one yet. IDs name checkpoint files,
+// so only letters, digits, - and _ are allowed
+func openSession(b *Broker, id string) (*session, error) {
+	if id == "" {
+		id = defaultSession
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return nil, errors.New(fmt.Sprint("session ID ", id, " can only have letters, digits, - and _ in it"))
+		}
+	}
+	b.sessionsMu.Lock()
+	defer b.sessionsMu.Unlock()
+	s, ok := b.sessions[id]
+	if !ok {
+		s = newSession(b, id)
+		b.sessions[id] = s
+	}
+	return s, nil
+}
+
+// keepSession : puts s back to be found by its ID, for Init and Restore giving it a new world after stopping one
+// that closed it on finishing
+func keepSession(s *session) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	if _, ok := s.sessions[s.id]; !ok {
+		s.sessions[s.id] = s
+	}
+}
+
+// closeSession : forgets s once its world has finished and the controller has had everything it needs from it,
+// so it doesn't keep the world and its flips for the life of the Broker. Does nothing if s has been given a new world since
+func closeSession(s *session) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	if s.sessions[s.id] == s && currentState(s) == stateFinished {
+		delete(s.sessions, s.id)
+		println("Closed session", s.id)
+	}
+}
+
+// allSessions : every session, in no particular order
+func allSessions(b *Broker) []*session {
+	b.sessionsMu.Lock()
+	defer b.sessionsMu.Unlock()
+	sessions := make([]*session, 0, len(b.sessions))
+	for _, s := range b.sessions {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// claimWorker : marks the worker at address as running s's world, failing if another session is using it
+func claimWorker(s *session, address string) error {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	if owner, claimed := s.claims[address]; claimed && owner != s.id {
+		return errors.New(fmt.Sprint("Worker ", address, " is running a world for session ", owner))
+	}
+	s.claims[address] = s.id
+	return nil
+}
+
+// releaseWorkers : lets other sessions use workers s was running its world on
+func releaseWorkers(s *session, addresses []string) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	for _, address := range addresses {
+		if s.claims[address] == s.id {
+			delete(s.claims, address)
+		}
+	}
+}
+
+// claimedBy : the session running a world on the worker at address, and whether there is one
+func claimedBy(b *Broker, address string) (string, bool) {
+	b.sessionsMu.Lock()
+	defer b.sessionsMu.Unlock()
+	owner, claimed := b.claims[address]
+	return owner, claimed
+}
+
+// retireWorkers : hangs up on the workers running s's world and lets other sessions use them,
+// the world has to be in s.world first if it is still wanted
+func retireWorkers(s *session) {
+	s.progressMu.Lock()
+	defer s.progressMu.Unlock()
+	for _, worker := range s.workers {
+		_ = worker.Close()
+	}
+	releaseWorkers(s, s.workersAdr)
+	s.workers, s.workersAdr, s.workerCapacity, s.workerCount = nil, nil, nil, 0
+}
+
+// checkpointPath : the file s checkpoints to, the Broker's own checkpoint file for the default session
+func checkpointPath(s *session) string {
+	if s.id == defaultSession {
+		return s.checkpointFile
+	}
+	extension := filepath.Ext(s.checkpointFile)
+	return strings.TrimSuffix(s.checkpointFile, extension) + "-" + s.id + extension
+}
+
+// registration : a Worker that registered itself with the Broker
+type registration struct {
+	capacity int
+	lastSeen time.Time
+}
+
+// hold : a request for an autonomous loop to stop every worker on the same turn
+type hold struct {
+	held    chan struct{} //closed by the loop once every worker has stopped
+	release chan struct{} //closed by whoever asked to let the workers carry on
+}
+
+// scale : a request to add or remove a worker while the world is running
+type scale struct {
+	add     bool
+	address string     //worker to add or remove, filled in by the loop if left empty
+	workers int        //workers running the world afterwards
+	done    chan error //sent whether it worked once the workers are running again
+}
+
+// runState : where the world on the Broker is in its run
+type runState int
+
+const (
+	stateInit     runState = iota //loaded by Init or Restore, not started yet
+	stateRunning                  //progressing towards the final turn
+	statePaused                   //stopped between turns until Resume
+	stateStepping                 //progressing towards stepUntil, then paused again
+	stateQuitting                 //asked to stop before the final turn
+	stateFinished                 //stopped, on the final turn or after quitting
+)
+
+var stateNames = map[runState]string{
+	stateInit:     "initialised",
+	stateRunning:  "running",
+	statePaused:   "paused",
+	stateStepping: "stepping",
+	stateQuitting: "quitting",
+	stateFinished: "finished",
+}
+
+func (state runState) String() string {
+	return stateNames[state]
+}
+
+// currentState : the state of the run
+func currentState(s *session) runState {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return s.state
+}
+
+// setState : moves the run to state, waking anything waiting for it to change
+func setState(s *session, state runState) {
+	s.stateMu.Lock()
+	s.state = state
+	s.stateChanged.Broadcast()
+	s.stateMu.Unlock()
+}
+
+// waitWhilePaused : called by a loop between turns, pausing a stepping world that has got to the turn it steps until.
+// Waits out a pause and returns whether to carry on running
+func waitWhilePaused(s *session) bool {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if s.state == stateStepping && s.currentTurn >= s.stepUntil {
+		s.state = statePaused
+	}
+	for s.state == statePaused {
+		s.parked = true
+		s.stateChanged.Broadcast()
+		s.stateChanged.Wait()
+	}
+	s.parked = false
+	return s.state == stateRunning || s.state == stateStepping
+}
+
+// stepStop : the turn a stepping world has to stop on, 0 when it isn't stepping
+func stepStop(s *session) int {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if s.state != stateStepping {
+		return 0
+	}
+	return s.stepUntil
+}
+
+// quit : asks a running, paused or stepping world to stop, letting workers held for a pause go so the loop can finish
+func quit(s *session) {
+	s.stateMu.Lock()
+	if s.state == stateRunning || s.state == statePaused || s.state == stateStepping {
+		s.state = stateQuitting
+		s.stateChanged.Broadcast()
+	}
+	unhold := s.unhold
+	s.unhold = nil
+	s.stateMu.Unlock()
+	if unhold != nil {
+		unhold()
+	}
+}
+
+// QueryState : Called by a controller on start to see if there is already a world running in its session it can attach to
+func (b *Broker) QueryState(req stubs.SessionReq, res *stubs.BrokerStateRes) (err error) {
+	s, err := findSession(b, req.Session)
+	if err != nil {
+		//Nothing has been run in the session yet
+		res.Details = "No world running"
+		return nil
+	}
+	s.runMu.Lock()
+	res.StillCalculating = s.isRunning
+	s.runMu.Unlock()
+
+	state := currentState(s)
+	s.progressMu.Lock()
+	res.Turn = s.currentTurn
+	s.progressMu.Unlock()
+	res.Paused = state == statePaused
+	res.Width = s.width
+	res.Height = s.height
+	if res.StillCalculating {
+		res.Details = fmt.Sprintf("Session %s: turn %d of %d on %dx%d %v with rule %v, %v", s.id, res.Turn, s.finalTurn, s.width, s.height, s.boundary, s.rule, state)
+		if s.hashLife != nil {
+			res.Details += " using HashLife"
+		}
+	} else {
+		res.Details = "No world running"
+	}
+	return
+}
+
+// stopRun : stops any ProgressAll loop left running by a controller that detached, before a new world replaces it
+func stopRun(s *session) {
+	s.runMu.Lock()
+	isRunning, runDone := s.isRunning, s.runDone
+	s.runMu.Unlock()
+	if !isRunning {
+		return
+	}
+
+	s.progressMu.Lock()
+	turn := s.currentTurn
+	s.progressMu.Unlock()
+	println("Stopping world left running at turn", turn)
+	quit(s)
+	<-runDone
+}
+
+func (b *Broker) Init(req stubs.BrokerInitReq, res *stubs.None) (err error) {
+	s, err := openSession(b, req.Session)
+	if err != nil {
+		return
+	}
+	stopRun(s)
+	keepSession(s)
+
+	s.world = req.World
+	s.worldTurn = 0
+	s.currentTurn = 0
+	s.finalTurn = req.Turns
+	s.width = req.Width
+	s.height = req.Height
+	s.rule = req.Rule
+	s.boundary = req.Boundary
+	setState(s, stateInit)
+	s.printProgress = req.PrintProgress
+	s.lastCheckpoint = time.Now()
+
+	println("Broker created session", s.id, "on world", s.width, "x", s.height, "with rule", s.rule.String(), "on a", s.boundary.String(), ".")
+	if s.printProgress {
+		println("World at init. Turn:", s.currentTurn)
+		util.VisualiseMatrix(s.world.Unpack(), s.width, s.height)
+	}
+
+	return startHashLife(s, req.HashLife, req.HashLifeStep)
+}
+
+// Restore : Called instead of Init to carry on from the last checkpoint written to disk
+func (b *Broker) Restore(req stubs.BrokerRestoreReq, res *stubs.BrokerRestoreRes) (err error) {
+	s, err := openSession(b, req.Session)
+	if err != nil {
+		return
+	}
+	stopRun(s)
+	keepSession(s)
+	saved, err := checkpoint.Load(checkpointPath(s))
+	if err != nil {
+		return errors.New(fmt.Sprint("Error in Broker loading checkpoint: ", err.Error()))
+	}
+
+	s.world = saved.World
+	s.worldTurn = saved.Turn
+	s.currentTurn = saved.Turn
+	s.finalTurn = req.Turns
+	s.width = saved.Width
+	s.height = saved.Height
+	s.rule = util.Conway
+	if saved.Rule != "" {
+		s.rule, err = util.ParseRule(saved.Rule)
+		if err != nil {
+			return errors.New(fmt.Sprint("Error in Broker loading checkpoint: ", err.Error()))
+		}
+	}
+	s.boundary = util.Torus
+	if saved.Boundary != "" {
+		s.boundary, err = util.ParseBoundary(saved.Boundary)
+		if err != nil {
+			return errors.New(fmt.Sprint("Error in Broker loading checkpoint: ", err.Error()))
+		}
+	}
+	setState(s, stateInit)
+	s.printProgress = req.PrintProgress
+	s.lastCheckpoint = time.Now()
+
+	println("Broker resumed session", s.id, "from", checkpointPath(s), "on world", s.width, "x", s.height, "with rule", s.rule.String(), "on a", s.boundary.String(), "at turn", s.currentTurn, ".")
+	if s.printProgress {
+		util.VisualiseMatrix(s.world.Unpack(), s.width, s.height)
+	}
+	err = startHashLife(s, req.HashLife, req.HashLifeStep)
+	if err != nil {
+		return
+	}
+
+	res.World = s.world
+	res.Width = s.width
+	res.Height = s.height
+	res.Turn = s.currentTurn
+	res.Rule = s.rule
+	res.Boundary = s.boundary
+	return
+}
+
+// startHashLife : sets the world up to run with HashLife on the Broker if asked to, otherwise leaves it to the workers
+func startHashLife(s *session, useHashLife bool, step int) error {
+	s.hashLife = nil
+	if !useHashLife {
+		return nil
+	}
+	if s.boundary != util.Torus {
+		return errors.New(fmt.Sprint("HashLife can only run on a torus, not a ", s.boundary.String()))
+	}
+	universe, err := hashlife.New(s.world, s.rule, s.worldTurn)
+	if err != nil {
+		return errors.New(fmt.Sprint("Error in Broker starting HashLife: ", err.Error()))
+	}
+	s.hashLife = universe
+	s.hashLifeStep = step
+	println("Running with HashLife, up to", 1<<uint(step), "turns at a time.")
+	return nil
+}
+
+func (b *Broker) Start(req stubs.BrokerStartReq, res *stubs.None) (err error) {
+	s, err := findSession(b, req.Session)
+	if err != nil {
+		return
+	}
+	//Let go of any workers from this session's last world, then rpc Dial each worker
+	retireWorkers(s)
+	s.workers = make([]*rpc.Client, 0)
+	s.workersAdr = make([]string, 0)
+	s.workerCapacity = make([]int, 0)
+	s.workerCount = 0
+	if s.hashLife != nil {
+		//HashLife runs on the Broker by itself
+		return
+	}
+	s.tiled = req.Tiled
+	s.haloDepth = req.HaloDepth
+	if req.WorkerCount < 0 {
+		return errors.New(fmt.Sprint("can't split the world into ", req.WorkerCount, " bands"))
+	}
+	workerAddresses := req.WorkerAddresses
+	workerCapacities := make([]int, len(workerAddresses))
+	registered := len(workerAddresses) == 0
+	if registered {
+		workerAddresses, workerCapacities = freeWorkers(s)
+		if len(workerAddresses) == 0 {
+			return errors.New("no Worker addresses given and no Workers registered with Broker that another session isn't using")
+		}
+	}
+	//Only use as many workers as there are bands, any more bands are shared out between them
+	wanted := len(workerAddresses)
+	if req.WorkerCount > 0 && wanted > req.WorkerCount {
+		wanted = req.WorkerCount
+	} else if registered && (req.WorkerCount == 0 || req.WorkerCount > wanted) {
+		//Sharing bands out, or one per Worker, would take every free worker, so leave some for the next session
+		wanted = fairShare(wanted)
+	}
+	for i, workerAdr := range workerAddresses {
+		if s.workerCount == wanted {
+			break
+		}
+		claimErr := claimWorker(s, workerAdr)
+		if claimErr != nil && registered {
+			//Another session took it since
+			continue
+		} else if claimErr != nil {
+			retireWorkers(s)
+			return claimErr
+		}
+		worker, dialErr := dialWorker(s, workerAdr)
+		if dialErr != nil && registered {
+			//Died since its last heartbeat, so move on to the next free one
+			releaseWorkers(s, []string{workerAdr})
+			println("Can't connect to Worker at", workerAdr+", forgetting it:", dialErr.Error())
+			forgetWorker(b, workerAdr)
+			continue
+		} else if dialErr != nil {
+			releaseWorkers(s, []string{workerAdr})
+			retireWorkers(s)
+			return errors.New(fmt.Sprint("Error in Broker connecting to Worker: ", dialErr.Error()))
+		}
+		s.workers = append(s.workers, worker)
+		s.workersAdr = append(s.workersAdr, workerAdr)
+		s.workerCapacity = append(s.workerCapacity, workerCapacities[i])
+		s.workerCount++
+	}
+	if s.workerCount == 0 {
+		return errors.New("none of the Workers registered with Broker could be connected to")
+	}
+
+	s.bandCount, err = util.CountBands(req.WorkerCount, s.workerCount, s.height)
+	if err != nil {
+		retireWorkers(s)
+		return err
+	}
+	return s.distributeWorld()
+}
+
+// distributeWorld : splits s.world between the connected workers and links them into a halo grid,
+// all workers start again from s.worldTurn
+func (s *session) distributeWorld() (err error) {
+	s.epoch++
+	s.currentTurn = s.worldTurn
+
+	//Divide board up into tiles to give to each worker, worker i covers
+	//rows workerSections[row] to workerSections[row+1] and columns workerColumns[col] to workerColumns[col+1]
+	s.gridRows, s.gridCols = s.workerCount, 1
+	if s.tiled {
+		s.gridRows, s.gridCols = util.ChooseGrid(s.workerCount, s.width, s.height, s.boundary)
+	}
+	if s.gridRows > s.height || s.gridCols > s.width {
+		return errors.New(fmt.Sprintf("can't split a %dx%d world between %d Workers, use fewer", s.width, s.height, s.workerCount))
+	}
+	bands := util.ShareBands(s.bandCount, s.workerCount)
+	if s.tiled {
+		s.workerSections = util.SplitSections(s.height, s.gridRows, false)
+	} else if s.bandCount > s.workerCount {
+		//Workers calculating more bands get more rows
+		s.workerSections = util.SplitWeighted(s.height, bands)
+	} else {
+		//Bands of rows can be sized to how much each worker said it could take
+		s.workerSections = util.SplitWeighted(s.height, s.workerCapacity)
+	}
+	//Wrapping round a klein bottle mirrors the columns, so each column of tiles must line up with its mirror image
+	s.workerColumns = util.SplitSections(s.width, s.gridCols, s.boundary == util.KleinBottle)
+	//Workers swap their halos with their neighbours' sections, so they can't be deeper than the smallest section
+	haloDepth := s.haloDepth
+	for i := 0; i < s.workerCount; i++ {
+		top, bottom, left, right := tileBounds(s, i)
+		if bottom-top < haloDepth {
+			haloDepth = bottom - top
+		}
+		if right-left < haloDepth {
+			haloDepth = right - left
+		}
+	}
+	s.workerDepth = haloDepth
+	s.workerBusy = make([]time.Duration, s.workerCount)
+
+	//Distribute world to workers
+	workerDones := make([]*rpc.Call, s.workerCount)
+
+	//Call Init on each worker
+	for i := 0; i < s.workerCount; i++ {
+		top, bottom, left, right := tileBounds(s, i)
+		workerInitReq := stubs.WorkerInitReq{
+			World:         s.world.Block(left, top, right-left, bottom-top),
+			Width:         right - left,
+			Height:        bottom - top,
+			Turn:          s.worldTurn,
+			Epoch:         s.epoch,
+			Rule:          s.rule,
+			Boundary:      s.boundary,
+			HaloDepth:     haloDepth,
+			PrintProgress: s.printProgress,
+		}
+		if s.bandCount > s.workerCount {
+			workerInitReq.Threads = bands[i]
+		}
+		workerDones[i] = s.workers[i].Go(stubs.WorkerInit, workerInitReq, &stubs.None{}, nil)
+	}
+	//ensure each Init has completed
+	for i := 0; i < s.workerCount; i++ {
+		<-workerDones[i].Done
+		if workerDones[i].Error != nil {
+			println("Worker init err", workerDones[i].Error.Error())
+			return errors.New(fmt.Sprint("Error in Broker calling Init on Worker: ", workerDones[i].Error.Error()))
+		}
+	}
+
+	//Call Start on each worker
+	for i := 0; i < s.workerCount; i++ {
+		row, col := i/s.gridCols, i%s.gridCols
+		//the top row of tiles connects to the bottom row, if the boundary wraps
+		aboveRow, aboveCol := (row-1+s.gridRows)%s.gridRows, col
+		if row == 0 && s.boundary == util.KleinBottle {
+			aboveCol = s.gridCols - 1 - col
+		}
+		workerStartReq := stubs.WorkerStartReq{
+			AboveAdr:   s.workersAdr[aboveRow*s.gridCols+aboveCol],
+			TopEdge:    row == 0,
+			BottomEdge: row == s.gridRows-1,
+			LeftEdge:   col == 0,
+			RightEdge:  col == s.gridCols-1,
+		}
+		if s.gridCols > 1 {
+			//the left column of tiles connects to the right column, if the boundary wraps
+			workerStartReq.LeftAdr = s.workersAdr[row*s.gridCols+(col-1+s.gridCols)%s.gridCols]
+		}
+		workerDones[i] = s.workers[i].Go(stubs.WorkerStart, workerStartReq, &stubs.None{}, nil)
+	}
+	//ensure each Start has completed
+	for i := 0; i < s.workerCount; i++ {
+		<-workerDones[i].Done
+		if workerDones[i].Error != nil {
+			println("Worker start err", workerDones[i].Error.Error())
+			return errors.New(fmt.Sprint("Error in Broker calling Start on Worker: ", workerDones[i].Error.Error()))
+		}
+	}
+
+	return //Return no error
+}
+
+// tileBounds : the rows and columns of the world held by worker i
+func tileBounds(s *session, i int) (top, bottom, left, right int) {
+	row, col := i/s.gridCols, i%s.gridCols
+	return s.workerSections[row], s.workerSections[row+1], s.workerColumns[col], s.workerColumns[col+1]
+}
+
+// ProgressAll : Called by the controller to run the world until the final turn, returning the world it finished on.
+// A controller attaching to an already running world just waits for it to finish
+func (b *Broker) ProgressAll(req stubs.SessionReq, res *stubs.WorldRes) (err error) {
+	s, err := findSession(b, req.Session)
+	if err != nil {
+		return
+	}
+	s.runMu.Lock()
+	if s.isRunning {
+		runDone := s.runDone
+		s.runMu.Unlock()
+		s.progressMu.Lock()
+		turn := s.currentTurn
+		s.progressMu.Unlock()
+		println("Controller attached at turn", turn)
+		<-runDone
+		res.Turn, res.World, _ = collectWorldFromWorkers(s)
+		return s.runErr
+	}
+	s.isRunning = true
+	s.runDone = make(chan struct{})
+	s.runMu.Unlock()
+
+	s.stateMu.Lock()
+	if s.state == stateInit {
+		s.state = stateRunning
+		s.stateChanged.Broadcast()
+	}
+	s.stateMu.Unlock()
+	if s.hashLife != nil {
+		err = hashLifeLoop(s)
+	} else if s.autonomous {
+		err = autonomousLoop(s)
+	} else {
+		err = progressLoop(s)
+	}
+
+	//Keep the world on the Broker and let other sessions have the workers
+	if s.hashLife == nil && s.workerCount > 0 {
+		snapshotErr := takeSnapshot(s)
+		if snapshotErr != nil {
+			println("Error in Broker fetching the world before letting go of the Workers:", snapshotErr.Error())
+		}
+		retireWorkers(s)
+	}
+	res.Turn, res.World, _ = collectWorldFromWorkers(s)
+	setState(s, stateFinished)
+	//A subscribed controller may still have flips to collect, then Flips closes the session once they have all gone
+	if streaming, _ := streamState(s); !streaming || streamDrained(s) {
+		closeSession(s)
+	}
+	s.runMu.Lock()
+	if err == nil && s.stopReason != nil {
+		err = s.stopReason
+	}
+	s.isRunning = false
+	s.runErr = err
+	//Nobody is left to add or remove workers still waiting
+	select {
+	case sc := <-s.scales:
+		sc.done <- errors.New("world stopped before the Workers could be changed")
+	default:
+	}
+	close(s.runDone)
+	s.runMu.Unlock()
+	return
+}
+
+// progressLoop : progresses the workers one turn at a time until the final turn or until told to quit
+func progressLoop(s *session) error {
+	//MAIN LOOP:
+	for s.currentTurn < s.finalTurn && waitWhilePaused(s) {
+		streaming, resync := streamState(s)
+		turn, flipped, err := progressWorkers(s, streaming && !resync)
+		if err == nil && checkpointDue(s, turn) {
+			err = takeSnapshot(s)
+			if err == nil {
+				savePeriodicCheckpoint(s)
+			}
+		} else if err == nil && s.snapshotInterval > 0 && turn%s.snapshotInterval == 0 {
+			err = takeSnapshot(s)
+		}
+		if err == nil && s.balanceEvery > 0 && turn%s.balanceEvery == 0 {
+			err = rebalanceWorkers(s)
+		}
+		if err == nil && len(s.scales) > 0 {
+			err = scaleWorkers(s, <-s.scales, turn)
+		}
+		if err != nil {
+			println("Error in Broker progressing Workers:", err.Error())
+			err = recoverWorkers(s)
+			if err != nil {
+				return errors.New(fmt.Sprint("Error in Broker recovering Workers: ", err.Error()))
+			}
+			//The world has gone back to the snapshot, so the controller needs to resync
+			setResync(s)
+			continue
+		}
+		s.progressMu.Lock()
+		s.currentTurn = turn
+		s.progressMu.Unlock()
+
+		if streaming && !resync {
+			publishFlips(s, stubs.TurnDiff{Turn: turn, Cells: flipped})
+		} else if streaming && len(s.flips) == 0 {
+			//Controller has caught up, so send it the whole world to carry on from
+			publishKeyframe(s)
+		}
+	}
+
+	println("Broker finished calculating world at turn", s.currentTurn, "out of", s.finalTurn)
+	finishStream(s)
+	return nil
+}
+
+// finishStream : sends the controller a last keyframe if it needs one, then lets it know there are no more flips coming
+func finishStream(s *session) {
+	if streaming, resync := streamState(s); streaming && resync {
+		publishKeyframe(s)
+	}
+	s.streamMu.Lock()
+	select {
+	case <-s.streamDone: //already closed by an earlier world nobody subscribed to since
+	default:
+		close(s.streamDone)
+	}
+	s.streamMu.Unlock()
+}
+
+// autonomousLoop : lets the workers run by themselves with RunUntil, only stopping them all on the same turn
+// for snapshots and checkpoints, or when the controller pauses, counts, fetches or quits
+func autonomousLoop(s *session) error {
+	var holds []hold
+	for {
+		//Every worker is stopped on s.currentTurn here, so the world is consistent for whoever asked for a hold
+		for _, h := range holds {
+			close(h.held)
+			<-h.release
+		}
+		holds = nil
+		if s.currentTurn >= s.finalTurn || !waitWhilePaused(s) {
+			break
+		}
+
+		streaming, resync := streamState(s)
+		if streaming && resync && len(s.flips) == 0 {
+			//Controller has caught up, so send it the whole world to carry on from
+			publishKeyframe(s)
+			streaming, resync = streamState(s)
+		}
+		var err error
+		holds, err = runWorkersUntil(s, nextStop(s), streaming && !resync)
+		if err == nil && checkpointDue(s, s.currentTurn) {
+			err = takeSnapshot(s)
+			if err == nil {
+				savePeriodicCheckpoint(s)
+			}
+		} else if err == nil && s.snapshotInterval > 0 && s.currentTurn%s.snapshotInterval == 0 {
+			err = takeSnapshot(s)
+		}
+		if err == nil && s.balanceEvery > 0 && s.currentTurn%s.balanceEvery == 0 {
+			err = rebalanceWorkers(s)
+		}
+		if err == nil && len(s.scales) > 0 {
+			err = scaleWorkers(s, <-s.scales, s.currentTurn)
+		}
+		if err != nil {
+			println("Error in Broker running Workers:", err.Error())
+			err = recoverWorkers(s)
+			if err != nil {
+				for _, h := range holds {
+					close(h.held)
+				}
+				return errors.New(fmt.Sprint("Error in Broker recovering Workers: ", err.Error()))
+			}
+			//The world has gone back to the snapshot, so the controller needs to resync
+			setResync(s)
+		}
+	}
+
+	println("Broker finished calculating world at turn", s.currentTurn, "out of", s.finalTurn)
+	finishStream(s)
+	return nil
+}
+
+// hashLifeLoop : jumps the world on with HashLife until the final turn or until told to quit,
+// landing on every turn a checkpoint is due on and sending the controller the cells flipped by each jump
+func hashLifeLoop(s *session) error {
+	shown := s.hashLife.World() //world as the controller has it
+	for s.currentTurn < s.finalTurn && waitWhilePaused(s) {
+		stop := s.finalTurn
+		if s.checkpointTurns > 0 && (s.currentTurn/s.checkpointTurns+1)*s.checkpointTurns < stop {
+			stop = (s.currentTurn/s.checkpointTurns + 1) * s.checkpointTurns
+		}
+		if until := stepStop(s); until > 0 && until < stop {
+			stop = until
+		}
+		streaming, resync := streamState(s)
+
+		s.progressMu.Lock()
+		s.hashLife.Step(hashlife.StepFor(stop-s.currentTurn, s.hashLifeStep))
+		s.currentTurn = s.hashLife.Turn()
+		s.progressMu.Unlock()
+
+		if checkpointDue(s, s.currentTurn) {
+			//Can't fail without workers
+			_ = takeSnapshot(s)
+			savePeriodicCheckpoint(s)
+		}
+		if streaming && !resync {
+			world := s.hashLife.World()
+			publishFlips(s, stubs.TurnDiff{Turn: s.currentTurn, Cells: util.EncodeFlips(util.Flipped(shown, world))})
+			shown = world
+		} else if streaming && len(s.flips) == 0 {
+			//Controller has caught up, so send it the whole world to carry on from
+			publishKeyframe(s)
+			shown = s.hashLife.World()
+		}
+	}
+
+	println("Broker finished calculating world at turn", s.currentTurn, "out of", s.finalTurn)
+	finishStream(s)
+	return nil
+}
+
+// nextStop : the next turn every worker has to stop on, for a snapshot, a checkpoint, a rebalance,
+// the end of a step or the end of the run
+func nextStop(s *session) int {
+	stop := s.finalTurn
+	for _, every := range []int{s.snapshotInterval, s.checkpointTurns, s.balanceEvery} {
+		if every > 0 && (s.currentTurn/every+1)*every < stop {
+			stop = (s.currentTurn/every + 1) * every
+		}
+	}
+	if until := stepStop(s); until > 0 && until < stop {
+		stop = until
+	}
+	return stop
+}
+
+// runWorkersUntil : lets the workers run by themselves up to the target turn, publishing their flips as they report them.
+// Stops them all early on the same turn to quit, to send a keyframe, to write a checkpoint that is due,
+// to add or remove a worker, or for a hold, returning the holds taken
+func runWorkersUntil(s *session, target int, flips bool) ([]hold, error) {
+	var holds []hold
+	_, err := setTargets(s, target, flips)
+	if err != nil {
+		return holds, err
+	}
+
+	reported := make([]int, s.workerCount)
+	pending := make([][]stubs.Turn, s.workerCount)
+	for i := range reported {
+		reported[i] = s.currentTurn
+	}
+	stopping := false
+	lastProgress := time.Now()
+	for s.currentTurn < target {
+		if !stopping {
+			select {
+			case h := <-s.holds:
+				holds = append(holds, h)
+				stopping = true
+			default:
+				streaming, resync := streamState(s)
+				stopping = currentState(s) == stateQuitting || (streaming && resync && len(s.flips) == 0) || len(s.scales) > 0 ||
+					(s.checkpointEvery > 0 && time.Since(s.lastCheckpoint) >= s.checkpointEvery)
+			}
+			if stopping {
+				target, err = stopWorkers(s, reported, flips)
+				if err != nil {
+					return holds, err
+				}
+			}
+		}
+
+		reports, err := reportWorkers(s)
+		if err != nil {
+			return holds, err
+		}
+		turn := target
+		for i, report := range reports {
+			s.workerBusy[i] += report.Busy
+			if flips {
+				pending[i] = append(pending[i], report.Turns...)
+				if len(report.Turns) > 0 {
+					reported[i] = report.Turns[len(report.Turns)-1].Turn
+				}
+			} else {
+				reported[i] = report.Turn
+			}
+			if reported[i] < turn {
+				turn = reported[i]
+			}
+		}
+
+		if turn > s.currentTurn {
+			lastProgress = time.Now()
+		} else if time.Since(lastProgress) > s.workerTimeout {
+			//Nobody has finished a turn in a long time, make sure nobody has silently disappeared
+			dead := findDeadWorkers(s)
+			if len(dead) > 0 {
+				return holds, errors.New(fmt.Sprint(len(dead), " Worker(s) stopped responding"))
+			}
+			lastProgress = time.Now()
+		}
+
+		//Publish every turn that all the workers have got to
+		for s.currentTurn < turn {
+			if flips {
+				workerFlips := make([][]uint32, s.workerCount)
+				for i := range pending {
+					workerFlips[i] = pending[i][0].Flipped
+					pending[i] = pending[i][1:]
+				}
+				//Once the controller has fallen behind it can't follow flips again until it has had a keyframe
+				if _, resync := streamState(s); !resync {
+					publishFlips(s, stubs.TurnDiff{Turn: s.currentTurn + 1, Cells: mergeFlips(s, workerFlips)})
+				}
+			}
+			s.progressMu.Lock()
+			s.currentTurn++
+			s.progressMu.Unlock()
+		}
+	}
+	return holds, nil
+}
+
+// setTargets : calls RunUntil on every worker, returning the furthest turn any of them is already on
+func setTargets(s *session, target int, flips bool) (int, error) {
+	workerDones := make([]*rpc.Call, s.workerCount)
+	workerTurnRes := make([]stubs.Turn, s.workerCount)
+	for i := 0; i < s.workerCount; i++ {
+		workerDones[i] = s.workers[i].Go(stubs.WorkerRunUntil, stubs.WorkerRunReq{Turn: target, Flips: flips}, &workerTurnRes[i], nil)
+	}
+	furthest := 0
+	for i := 0; i < s.workerCount; i++ {
+		<-workerDones[i].Done
+		if workerDones[i].Error != nil {
+			return 0, errors.New(fmt.Sprint("Error in Broker calling RunUntil on Worker: ", workerDones[i].Error.Error()))
+		}
+		if workerTurnRes[i].Turn > furthest {
+			furthest = workerTurnRes[i].Turn
+		}
+	}
+	return furthest, nil
+}
+
+// stopWorkers : gets every worker to stop on the same turn as soon as possible, returning that turn.
+// Workers may have got past the turn asked for by the time they hear about it, so keep asking for the furthest one
+// until they all agree, which they soon do as workers can't get far ahead of stopped neighbours
+func stopWorkers(s *session, reported []int, flips bool) (int, error) {
+	turn := 0
+	for _, reportedTurn := range reported {
+		if reportedTurn > turn {
+			turn = reportedTurn
+		}
+	}
+	for {
+		furthest, err := setTargets(s, turn, flips)
+		if err != nil {
+			return 0, err
+		}
+		if furthest <= turn {
+			return turn, nil
+		}
+		turn = furthest
+	}
+}
+
+// reportWorkers : calls Report on every worker, waiting for them all to reply
+func reportWorkers(s *session) ([]stubs.WorkerReportRes, error) {
+	workerDones := make(chan *rpc.Call, s.workerCount)
+	workerReportRes := make([]stubs.WorkerReportRes, s.workerCount)
+	for i := 0; i < s.workerCount; i++ {
+		s.workers[i].Go(stubs.WorkerReport, stubs.None{}, &workerReportRes[i], workerDones)
+	}
+
+	for remaining := s.workerCount; remaining > 0; {
+		select {
+		case call := <-workerDones:
+			if call.Error != nil {
+				return nil, call.Error
+			}
+			remaining--
+		case <-time.After(s.workerTimeout):
+			dead := findDeadWorkers(s)
+			if len(dead) > 0 {
+				return nil, errors.New(fmt.Sprint(len(dead), " Worker(s) stopped responding"))
+			}
+		}
+	}
+	return workerReportRes, nil
+}
+
+// holdWorkers : has an autonomous loop stop every worker on the same turn, returning once they have,
+// along with a func to let them carry on. Workers that aren't running by themselves are already in step
+func holdWorkers(s *session) (release func()) {
+	s.runMu.Lock()
+	isRunning, runDone := s.isRunning, s.runDone
+	s.runMu.Unlock()
+	if !runsAutonomously(s) || !isRunning || currentState(s) == statePaused {
+		return func() {}
+	}
+
+	h := hold{held: make(chan struct{}), release: make(chan struct{})}
+	for sent := false; !sent; {
+		select {
+		case s.holds <- h:
+			sent = true
+		case <-runDone:
+			return func() {}
+		case <-time.After(50 * time.Millisecond):
+			//The loop may have paused at the end of a step since, which leaves every worker stopped already
+			if currentState(s) == statePaused {
+				return func() {}
+			}
+		}
+	}
+	select {
+	case <-h.held:
+	case <-runDone:
+	}
+	return func() { close(h.release) }
+}
+
+// runsAutonomously : whether workers run the world by themselves, HashLife steps the world one jump at a time like progressLoop
+func runsAutonomously(s *session) bool {
+	return s.autonomous && s.hashLife == nil
+}
+
+// progressWorkers : calls Progress on every worker and waits for them all to finish the turn,
+// optionally collecting the cells that flipped across the whole world
+func progressWorkers(s *session, flips bool) (int, []uint32, error) {
+	workerDones := make(chan *rpc.Call, s.workerCount)
+	workerTurnRes := make([]stubs.Turn, s.workerCount)
+	for i := 0; i < s.workerCount; i++ {
+		s.workers[i].Go(stubs.WorkerProgress, stubs.WorkerProgressReq{Flips: flips}, &workerTurnRes[i], workerDones)
+	}
+
+	for remaining := s.workerCount; remaining > 0; {
+		select {
+		case call := <-workerDones:
+			if call.Error != nil {
+				return 0, nil, call.Error
+			}
+			remaining--
+		case <-time.After(s.workerTimeout):
+			//Turn is taking a long time, make sure nobody has silently disappeared
+			dead := findDeadWorkers(s)
+			if len(dead) > 0 {
+				return 0, nil, errors.New(fmt.Sprint(len(dead), " Worker(s) stopped responding"))
+			}
+		}
+	}
+	for i := 0; i < s.workerCount; i++ {
+		s.workerBusy[i] += workerTurnRes[i].Busy
+	}
+
+	var flipped []uint32
+	if flips {
+		workerFlips := make([][]uint32, s.workerCount)
+		for i := range workerFlips {
+			workerFlips[i] = workerTurnRes[i].Flipped
+		}
+		flipped = mergeFlips(s, workerFlips)
+	}
+	return workerTurnRes[0].Turn, flipped, nil
+}
+
+// rebalanceWorkers : moves rows between neighbouring workers so they all take about as long to calculate a turn,
+// going by how long each has spent calculating since the last rebalance. Only bands of rows are rebalanced, not tiles
+func rebalanceWorkers(s *session) error {
+	busy := s.workerBusy
+	s.workerBusy = make([]time.Duration, s.workerCount)
+	if s.gridCols != 1 || s.workerCount < 2 {
+		return nil
+	}
+
+	//Rows each worker can calculate a second
+	speeds := make([]float64, s.workerCount)
+	totalSpeed := 0.0
+	slowest, fastest := busy[0], busy[0]
+	for i := range speeds {
+		if busy[i] <= 0 {
+			return nil
+		}
+		speeds[i] = float64(s.workerSections[i+1]-s.workerSections[i]) / busy[i].Seconds()
+		totalSpeed += speeds[i]
+		if busy[i] > slowest {
+			slowest = busy[i]
+		}
+		if busy[i] < fastest {
+			fastest = busy[i]
+		}
+	}
+	//Not worth moving rows for a small difference
+	if float64(slowest) < 1.1*float64(fastest) {
+		return nil
+	}
+
+	//Move each boundary towards where sharing the rows out by speed would put it, but by no more than
+	//a third of the section either side, so every worker keeps some of its own rows
+	moves := make([]int, s.workerCount+1)
+	sections := make([]int, s.workerCount+1)
+	copy(sections, s.workerSections)
+	share := 0.0
+	moved := false
+	for i := 1; i < s.workerCount; i++ {
+		share += speeds[i-1]
+		target := int(math.Round(float64(s.height) * share / totalSpeed))
+		limit := s.workerSections[i] - s.workerSections[i-1]
+		if s.workerSections[i+1]-s.workerSections[i] < limit {
+			limit = s.workerSections[i+1] - s.workerSections[i]
+		}
+		limit /= 3
+		moves[i] = target - s.workerSections[i]
+		if moves[i] > limit {
+			moves[i] = limit
+		} else if moves[i] < -limit {
+			moves[i] = -limit
+		}
+		sections[i] += moves[i]
+		moved = moved || moves[i] != 0
+	}
+	for i := 0; i < s.workerCount; i++ {
+		//Workers swap halos with the sections either side, so none can be shallower than the halo
+		if sections[i+1]-sections[i] < s.workerDepth {
+			return nil
+		}
+	}
+	if !moved {
+		return nil
+	}
+
+	s.progressMu.Lock()
+	defer s.progressMu.Unlock()
+	workerRebalanceReqs := linkBands(s.workersAdr)
+	for i := range workerRebalanceReqs {
+		workerRebalanceReqs[i].Top, workerRebalanceReqs[i].Bottom = moves[i], moves[i+1]
+	}
+	err := moveRows(s.workers, workerRebalanceReqs)
+	if err != nil {
+		return err
+	}
+	s.workerSections = sections
+	println("Rebalanced Workers, sections now start on rows", fmt.Sprint(sections[:s.workerCount]))
+	return nil
+}
+
+// linkBands : the Rebalance requests that link workers holding bands of rows in this order into a halo ring,
+// each swapping rows with the one before it
+func linkBands(addresses []string) []stubs.WorkerRebalanceReq {
+	reqs := make([]stubs.WorkerRebalanceReq, len(addresses))
+	for i := range reqs {
+		reqs[i].AboveAdr = addresses[(i-1+len(addresses))%len(addresses)]
+		reqs[i].TopEdge = i == 0
+		reqs[i].BottomEdge = i == len(addresses)-1
+	}
+	return reqs
+}
+
+// moveRows : calls Rebalance on every worker, waiting for them all to move their rows and start the next turn
+func moveRows(workers []*rpc.Client, reqs []stubs.WorkerRebalanceReq) error {
+	workerDones := make([]*rpc.Call, len(workers))
+	for i := range workers {
+		workerDones[i] = workers[i].Go(stubs.WorkerRebalance, reqs[i], &stubs.None{}, nil)
+	}
+	for i := range workers {
+		<-workerDones[i].Done
+		if workerDones[i].Error != nil {
+			return errors.New(fmt.Sprint("Error in Broker calling Rebalance on Worker: ", workerDones[i].Error.Error()))
+		}
+	}
+	return nil
+}
+
+// scaleWorkers : adds or removes a worker between turns, with every worker stopped on turn.
+// Requests that can't be done are turned down without touching the workers, so only return an error if the workers need recovering
+func scaleWorkers(s *session, sc *scale, turn int) (err error) {
+	var refused error
+	if sc.add {
+		refused, err = addWorker(s, sc, turn)
+	} else {
+		refused, err = removeWorker(s, sc)
+	}
+	if refused != nil {
+		println("Not scaling Workers:", refused.Error())
+		sc.done <- refused
+		return nil
+	}
+	sc.workers = s.workerCount
+	sc.done <- err
+	return err
+}
+
+// addWorker : splits the biggest band of rows in two, splicing the new worker in below to take the bottom half.
+// Tiles are redistributed from scratch instead
+func addWorker(s *session, sc *scale, turn int) (refused error, err error) {
+	chosen := sc.address == ""
+	candidates := []string{sc.address}
+	if chosen {
+		//Take the most capable registered worker that isn't already running this world or another session's
+		candidates = nil
+		addresses, _ := freeWorkers(s)
+		for _, address := range addresses {
+			if workerIndex(s, address) == -1 {
+				candidates = append(candidates, address)
+			}
+		}
+		if len(candidates) == 0 {
+			return errors.New("no registered Workers left to add"), nil
+		}
+	} else if workerIndex(s, sc.address) != -1 {
+		return errors.New(fmt.Sprint("Worker ", sc.address, " is already running this world")), nil
+	}
+
+	split := 0
+	for i := 1; i < s.gridRows; i++ {
+		if s.workerSections[i+1]-s.workerSections[i] > s.workerSections[split+1]-s.workerSections[split] {
+			split = i
+		}
+	}
+	moved := (s.workerSections[split+1] - s.workerSections[split]) / 2
+	//Both halves still have to be as deep as the halo
+	if !s.tiled && moved < s.workerDepth {
+		return errors.New("sections are too small to split"), nil
+	}
+
+	//Move on to the next candidate if the Broker chose one that another session took or that died since its last heartbeat
+	var worker *rpc.Client
+	for _, address := range candidates {
+		refused = claimWorker(s, address)
+		if refused != nil {
+			continue
+		}
+		worker, err = dialWorker(s, address)
+		if err != nil {
+			releaseWorkers(s, []string{address})
+			refused = errors.New(fmt.Sprint("Error in Broker connecting to Worker: ", err.Error()))
+			if chosen {
+				println("Can't connect to Worker at", address+", forgetting it:", err.Error())
+				forgetWorker(s.Broker, address)
+			}
+			continue
+		}
+		sc.address = address
+		break
+	}
+	if worker == nil {
+		return refused, nil
+	}
+	capacity := 1
+	s.registryMu.Lock()
+	if registered, known := s.registry[sc.address]; known {
+		capacity = registered.capacity
+	}
+	s.registryMu.Unlock()
+
+	if s.tiled {
+		err = takeSnapshot(s)
+		if err != nil {
+			_ = worker.Close()
+			releaseWorkers(s, []string{sc.address})
+			return nil, err
+		}
+		s.progressMu.Lock()
+		defer s.progressMu.Unlock()
+		s.workers = append(s.workers, worker)
+		s.workersAdr = append(s.workersAdr, sc.address)
+		s.workerCapacity = append(s.workerCapacity, capacity)
+		s.workerCount++
+		println("Adding Worker "+sc.address+", restarting", s.workerCount, "Workers from turn", s.worldTurn)
+		return nil, s.distributeWorld()
+	}
+
+	//The new worker starts with no rows, and takes its half from the worker above in Rebalance
+	workerInitReq := stubs.WorkerInitReq{
+		World:         util.NewPackedWorld(s.width, 0),
+		Width:         s.width,
+		Turn:          turn,
+		Epoch:         s.epoch,
+		Rule:          s.rule,
+		Boundary:      s.boundary,
+		HaloDepth:     s.workerDepth,
+		PrintProgress: s.printProgress,
+	}
+	err = worker.Call(stubs.WorkerInit, workerInitReq, &stubs.None{})
+	if err != nil {
+		_ = worker.Close()
+		releaseWorkers(s, []string{sc.address})
+		return errors.New(fmt.Sprint("Error in Broker calling Init on Worker: ", err.Error())), nil
+	}
+
+	at := split + 1
+	workers := append(append(append([]*rpc.Client{}, s.workers[:at]...), worker), s.workers[at:]...)
+	workersAdr := append(append(append([]string{}, s.workersAdr[:at]...), sc.address), s.workersAdr[at:]...)
+	workerCapacity := append(append(append([]int{}, s.workerCapacity[:at]...), capacity), s.workerCapacity[at:]...)
+	sections := append(append(append([]int{}, s.workerSections[:at]...), s.workerSections[at]-moved), s.workerSections[at:]...)
+
+	s.progressMu.Lock()
+	defer s.progressMu.Unlock()
+	workerRebalanceReqs := linkBands(workersAdr)
+	workerRebalanceReqs[split].Bottom = -moved
+	workerRebalanceReqs[at].Top = -moved
+	workerRebalanceReqs[at].Join = true
+	err = moveRows(workers, workerRebalanceReqs)
+	if err != nil {
+		_ = worker.Close()
+		releaseWorkers(s, []string{sc.address})
+		return nil, err
+	}
+	s.workers, s.workersAdr, s.workerCapacity, s.workerSections = workers, workersAdr, workerCapacity, sections
+	s.workerCount++
+	s.gridRows++
+	s.workerBusy = make([]time.Duration, s.workerCount)
+	println("Added Worker "+sc.address+", sections now start on rows", fmt.Sprint(sections[:s.workerCount]))
+	return nil, nil
+}
+
+// removeWorker : drains a worker by merging its rows into the band above, or below for the top band.
+// Tiles are redistributed from scratch instead. Removes the last worker if none is given
+func removeWorker(s *session, sc *scale) (refused error, err error) {
+	if s.workerCount < 2 {
+		return errors.New("can't remove the last Worker"), nil
+	}
+	leaving := s.workerCount - 1
+	if sc.address != "" {
+		leaving = workerIndex(s, sc.address)
+		if leaving == -1 {
+			return errors.New(fmt.Sprint("Worker ", sc.address, " isn't running this world")), nil
+		}
+	}
+	sc.address = s.workersAdr[leaving]
+	workers := append(append([]*rpc.Client{}, s.workers[:leaving]...), s.workers[leaving+1:]...)
+	workersAdr := append(append([]string{}, s.workersAdr[:leaving]...), s.workersAdr[leaving+1:]...)
+	workerCapacity := append(append([]int{}, s.workerCapacity[:leaving]...), s.workerCapacity[leaving+1:]...)
+
+	if s.tiled {
+		err = takeSnapshot(s)
+		if err != nil {
+			return nil, err
+		}
+		s.progressMu.Lock()
+		defer s.progressMu.Unlock()
+		_ = s.workers[leaving].Close()
+		releaseWorkers(s, []string{sc.address})
+		s.workers, s.workersAdr, s.workerCapacity = workers, workersAdr, workerCapacity
+		s.workerCount--
+		println("Removing Worker "+sc.address+", restarting", s.workerCount, "Workers from turn", s.worldTurn)
+		return nil, s.distributeWorld()
+	}
+
+	//The top band goes to the worker below it, any other to the worker above
+	rows := s.workerSections[leaving+1] - s.workerSections[leaving]
+	merged := leaving
+	if leaving == 0 {
+		merged = 1
+	}
+	sections := append(append([]int{}, s.workerSections[:merged]...), s.workerSections[merged+1:]...)
+	workerRebalanceReqs := linkBands(workersAdr)
+	leaveReq := stubs.WorkerRebalanceReq{Leave: true}
+	if leaving > 0 {
+		leaveReq.Top = rows
+		workerRebalanceReqs[leaving-1].Bottom = rows
+	} else {
+		leaveReq.Bottom = -rows
+		workerRebalanceReqs[0].Top = -rows
+	}
+	workerRebalanceReqs = append(append(append([]stubs.WorkerRebalanceReq{}, workerRebalanceReqs[:leaving]...), leaveReq), workerRebalanceReqs[leaving:]...)
+
+	s.progressMu.Lock()
+	defer s.progressMu.Unlock()
+	err = moveRows(s.workers, workerRebalanceReqs)
+	if err != nil {
+		return nil, err
+	}
+	_ = s.workers[leaving].Close()
+	releaseWorkers(s, []string{sc.address})
+	s.workers, s.workersAdr, s.workerCapacity, s.workerSections = workers, workersAdr, workerCapacity, sections
+	s.workerCount--
+	s.gridRows--
+	s.workerBusy = make([]time.Duration, s.workerCount)
+	println("Removed Worker "+sc.address+", sections now start on rows", fmt.Sprint(sections[:s.workerCount]))
+	return nil, nil
+}
+
+// workerIndex : the index of the worker at address, or -1 if it isn't running this world
+func workerIndex(s *session, address string) int {
+	for i, workerAdr := range s.workersAdr {
+		if workerAdr == address {
+			return i
+		}
+	}
+	return -1
+}
+
+// mergeFlips : combines the flips each worker sent for a turn into the flips for the whole world
+func mergeFlips(s *session, workerFlips [][]uint32) []uint32 {
+	//Workers send indices within their own tile, so move them to the whole world
+	var indices []int
+	for i := 0; i < s.workerCount; i++ {
+		top, _, left, right := tileBounds(s, i)
+		for _, index := range util.DecodeFlips(workerFlips[i]) {
+			indices = append(indices, (top+index/(right-left))*s.width+left+index%(right-left))
+		}
+	}
+	//Tiles side by side interleave their rows, so put the indices back in order
+	sort.Ints(indices)
+	return util.EncodeFlips(indices)
+}
+
+// takeSnapshot : stores the current world so the run can be resumed from here if a worker crashes
+func takeSnapshot(s *session) error {
+	turn, world, err := collectWorldFromWorkers(s)
+	if err != nil {
+		return err
+	}
+	s.progressMu.Lock()
+	s.world = world
+	s.worldTurn = turn
+	s.progressMu.Unlock()
+	return nil
+}
+
+// Subscribe : Called by a controller that wants the cells flipped each turn sent to it through Flips
+func (b *Broker) Subscribe(req stubs.SubscribeReq, res *stubs.None) (err error) {
+	s, err := findSession(b, req.Session)
+	if err != nil {
+		return
+	}
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+	for len(s.flips) > 0 {
+		<-s.flips
+	}
+	s.isStreaming = true
+	s.resync = req.Resync
+	s.streamDone = make(chan struct{})
+	return
+}
+
+// Unsubscribe : Called by a controller that is leaving, so workers stop being held up waiting for it
+func (b *Broker) Unsubscribe(req stubs.SessionReq, res *stubs.None) (err error) {
+	s, err := findSession(b, req.Session)
+	if err != nil {
+		//Already closed, so there's nothing to stop sending
+		return nil
+	}
+	s.streamMu.Lock()
+	s.isStreaming = false
+	for len(s.flips) > 0 {
+		<-s.flips
+	}
+	s.streamMu.Unlock()
+	closeSession(s)
+	return
+}
+
+// Flips : Called repeatedly by a subscribed controller, waits briefly for flips then returns all that are ready
+func (b *Broker) Flips(req stubs.SessionReq, res *stubs.FlipsRes) (err error) {
+	s, err := findSession(b, req.Session)
+	if err != nil {
+		//Closed once its last flips were sent
+		res.Finished = true
+		return nil
+	}
+	s.streamMu.Lock()
+	streamDone := s.streamDone
+	s.streamMu.Unlock()
+
+	select {
+	case diff := <-s.flips:
+		res.Diffs = append(res.Diffs, diff)
+	case <-streamDone:
+	case <-time.After(time.Second):
+	}
+	//Take whatever else is already waiting
+	for waiting := true; waiting && len(res.Diffs) < cap(s.flips); {
+		select {
+		case diff := <-s.flips:
+			res.Diffs = append(res.Diffs, diff)
+		default:
+			waiting = false
+		}
+	}
+
+	select {
+	case <-streamDone:
+		res.Finished = len(s.flips) == 0
+	default:
+	}
+	if res.Finished {
+		closeSession(s)
+	}
+	return
+}
+
+// streamDrained : whether the last flips of the world have been queued and collected
+func streamDrained(s *session) bool {
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+	select {
+	case <-s.streamDone:
+		return len(s.flips) == 0
+	default:
+		return false
+	}
+}
+
+// streamState : whether a controller is subscribed, and whether it needs a keyframe
+func streamState(s *session) (bool, bool) {
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+	return s.isStreaming, s.resync
+}
+
+func setResync(s *session) {
+	s.streamMu.Lock()
+	s.resync = true
+	s.streamMu.Unlock()
+}
+
+// publishFlips : queues a turn for the controller, holding up the workers for at most streamWait if it is behind
+func publishFlips(s *session, diff stubs.TurnDiff) {
+	select {
+	case s.flips <- diff:
+	case <-time.After(s.streamWait):
+		println("Controller fell behind at turn", diff.Turn, ", skipping to a keyframe once it catches up")
+		setResync(s)
+	}
+}
+
+// publishKeyframe : queues every alive cell in the world so the controller can start following flips again
+func publishKeyframe(s *session) {
+	turn, world, err := collectWorldFromWorkers(s)
+	if err != nil {
+		println("Error in Broker collecting keyframe:", err.Error())
+		return
+	}
+	var alive []int
+	for y := 0; y < s.height; y++ {
+		for x := 0; x < s.width; x++ {
+			if world.Alive(x, y) {
+				alive = append(alive, y*s.width+x)
+			}
+		}
+	}
+
+	s.streamMu.Lock()
+	s.resync = false
+	s.streamMu.Unlock()
+	publishFlips(s, stubs.TurnDiff{Turn: turn, Keyframe: true, Cells: util.EncodeFlips(alive)})
+}
+
+// checkpointDue : whether a checkpoint should be written to disk after this turn
+func checkpointDue(s *session, turn int) bool {
+	if s.checkpointTurns > 0 && turn%s.checkpointTurns == 0 {
+		return true
+	}
+	return s.checkpointEvery > 0 && time.Since(s.lastCheckpoint) >= s.checkpointEvery
+}
+
+// saveCheckpoint : writes the latest snapshot to disk
+func saveCheckpoint(s *session) error {
+	s.lastCheckpoint = time.Now()
+	err := checkpoint.Save(checkpointPath(s), checkpoint.Checkpoint{
+		Turn:     s.worldTurn,
+		Width:    s.width,
+		Height:   s.height,
+		Rule:     s.rule.String(),
+		Boundary: s.boundary.String(),
+		World:    s.world,
+	})
+	if err != nil {
+		return err
+	}
+	println("Checkpoint of session", s.id, "saved at turn", s.worldTurn)
+	return nil
+}
+
+// savePeriodicCheckpoint : saves a checkpoint during a run, which carries on if it can't be written.
+// The file then still holds the last checkpoint that could be, so resuming would go back to that one
+func savePeriodicCheckpoint(s *session) {
+	err := saveCheckpoint(s)
+	if err != nil {
+		println("Error in Broker saving checkpoint of session", s.id, "at turn", s.worldTurn, "so resuming will go back to the last one saved:", err.Error())
+	}
+}
+
+// findDeadWorkers : pings every worker and returns the indices of those that don't reply in time
+func findDeadWorkers(s *session) []int {
+	workerDones := make([]*rpc.Call, s.workerCount)
+	for i := 0; i < s.workerCount; i++ {
+		workerDones[i] = s.workers[i].Go(stubs.WorkerPing, stubs.None{}, &stubs.None{}, nil)
+	}
+
+	deadline := time.Now().Add(s.workerTimeout)
+	var dead []int
+	for i := 0; i < s.workerCount; i++ {
+		select {
+		case <-workerDones[i].Done:
+			if workerDones[i].Error == nil {
+				continue
+			}
+		case <-time.After(time.Until(deadline)):
+		}
+		dead = append(dead, i)
+	}
+	return dead
+}
+
+// recoverWorkers : drops any workers that have died and restarts the rest from the last snapshot
+func recoverWorkers(s *session) (err error) {
+	s.progressMu.Lock()
+	defer s.progressMu.Unlock()
+	for {
+		dead := findDeadWorkers(s)
+		if err != nil && len(dead) == 0 {
+			//Redistributing failed without anyone dying, so trying again won't help
+			return err
+		}
+
+		for i := len(dead) - 1; i >= 0; i-- {
+			d := dead[i]
+			println("Worker", s.workersAdr[d], "died, removing it.")
+			_ = s.workers[d].Close()
+			releaseWorkers(s, s.workersAdr[d:d+1])
+			s.workers = append(s.workers[:d], s.workers[d+1:]...)
+			s.workersAdr = append(s.workersAdr[:d], s.workersAdr[d+1:]...)
+			s.workerCapacity = append(s.workerCapacity[:d], s.workerCapacity[d+1:]...)
+			s.workerCount--
+		}
+		if s.workerCount == 0 {
+			return errors.New("no Workers left")
+		}
+
+		println("Restarting", s.workerCount, "Workers from turn", s.worldTurn)
+		err = s.distributeWorld()
+		if err == nil {
+			return
+		}
+	}
+}
+
+// Register : Called by a Worker when it starts, so controllers can use it without being given its address
+func (b *Broker) Register(req stubs.RegisterReq, res *stubs.None) (err error) {
+	b.registryMu.Lock()
+	defer b.registryMu.Unlock()
+	if _, known := b.registry[req.Address]; !known {
+		println("Worker registered at", req.Address, "with capacity", req.Capacity)
+	}
+	b.registry[req.Address] = &registration{capacity: req.Capacity, lastSeen: time.Now()}
+	return
+}
+
+// Heartbeat : Called regularly by registered Workers to show they are still there
+func (b *Broker) Heartbeat(req stubs.RegisterReq, res *stubs.HeartbeatRes) (err error) {
+	b.registryMu.Lock()
+	defer b.registryMu.Unlock()
+	worker, known := b.registry[req.Address]
+	if known {
+		worker.lastSeen = time.Now()
+	}
+	res.Registered = known
+	return
+}
+
+// registeredWorkers : the addresses and capacities of the Workers that have sent a heartbeat recently
+// and aren't running another session's world, most capable first, forgetting any that haven't sent one
+func registeredWorkers(b *Broker) ([]string, []int) {
+	b.registryMu.Lock()
+	defer b.registryMu.Unlock()
+	var addresses []string
+	for address, worker := range b.registry {
+		if time.Since(worker.lastSeen) > b.heartbeatTimeout {
+			println("Worker at", address, "stopped sending heartbeats, forgetting it.")
+			delete(b.registry, address)
+			continue
+		}
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool {
+		if b.registry[addresses[i]].capacity != b.registry[addresses[j]].capacity {
+			return b.registry[addresses[i]].capacity > b.registry[addresses[j]].capacity
+		}
+		return addresses[i] < addresses[j]
+	})
+	capacities := make([]int, len(addresses))
+	for i, address := range addresses {
+		capacities[i] = b.registry[address].capacity
+	}
+	return addresses, capacities
+}
+
+// forgetWorker : takes the worker at address out of the registry, it has to register again to be used
+func forgetWorker(b *Broker, address string) {
+	b.registryMu.Lock()
+	defer b.registryMu.Unlock()
+	delete(b.registry, address)
+}
+
+// fairShare : how many of free registered workers a session takes when it hasn't asked for a number it can have,
+// half of them rounded up so there are always some left for a session started after it
+func fairShare(free int) int {
+	return (free + 1) / 2
+}
+
+// freeWorkers : the registered Workers s could run its world on, those not running another session's
+func freeWorkers(s *session) ([]string, []int) {
+	addresses, capacities := registeredWorkers(s.Broker)
+	var free []string
+	var freeCapacities []int
+	for i, address := range addresses {
+		if owner, claimed := claimedBy(s.Broker, address); !claimed || owner == s.id {
+			free = append(free, address)
+			freeCapacities = append(freeCapacities, capacities[i])
+		}
+	}
+	return free, freeCapacities
+}
+
+// Leave : Called by a Worker shutting down, forgets it and hands any rows it has of the world it is running to a neighbour
+func (b *Broker) Leave(req stubs.RegisterReq, res *stubs.None) (err error) {
+	forgetWorker(b, req.Address)
+	println("Worker", req.Address, "is leaving")
+
+	owner, claimed := claimedBy(b, req.Address)
+	if !claimed {
+		return
+	}
+	s, err := findSession(b, owner)
+	if err != nil {
+		return
+	}
+	s.runMu.Lock()
+	isRunning := s.isRunning
+	s.runMu.Unlock()
+	s.progressMu.Lock()
+	inUse := workerIndex(s, req.Address) != -1
+	s.progressMu.Unlock()
+	if !isRunning || !inUse {
+		return
+	}
+	return requestScale(s, &scale{add: false, address: req.Address}, &stubs.ScaleRes{})
+}
+
+// AddWorker : Called by the controller to have another worker join the running world, taking rows from the biggest section
+func (b *Broker) AddWorker(req stubs.ScaleReq, res *stubs.ScaleRes) (err error) {
+	s, err := findSession(b, req.Session)
+	if err != nil {
+		return
+	}
+	return requestScale(s, &scale{add: true, address: req.Address}, res)
+}
+
+// RemoveWorker : Called by the controller to have a worker leave the running world, handing its rows to a neighbour
+func (b *Broker) RemoveWorker(req stubs.ScaleReq, res *stubs.ScaleRes) (err error) {
+	s, err := findSession(b, req.Session)
+	if err != nil {
+		return
+	}
+	return requestScale(s, &scale{add: false, address: req.Address}, res)
+}
+
+// requestScale : hands sc to the running loop, which carries it out between turns, and waits for it to be done
+func requestScale(s *session, sc *scale, res *stubs.ScaleRes) (err error) {
+	if s.hashLife != nil {
+		return errors.New("can't add or remove Workers from a world running with HashLife")
+	}
+	if currentState(s) == statePaused {
+		return errors.New("can't add or remove Workers while paused")
+	}
+	sc.done = make(chan error, 1)
+	s.runMu.Lock()
+	if !s.isRunning {
+		s.runMu.Unlock()
+		return errors.New("no world running to add or remove Workers from")
+	}
+	select {
+	case s.scales <- sc:
+	default:
+		s.runMu.Unlock()
+		return errors.New("already adding or removing a Worker")
+	}
+	s.runMu.Unlock()
+
+	err = <-sc.done
+	if err != nil {
+		return
+	}
+	res.Address = sc.address
+	res.WorkerCount = sc.workers
+	return
+}
+
+func (b *Broker) Count(req stubs.SessionReq, res *stubs.CountCellRes) (err error) {
+	s, err := findSession(b, req.Session)
+	if err != nil {
+		//The world may have just finished and its session been closed, so skip this count
+		res.Count = -1
+		return nil
+	}
+	if s.hashLife != nil {
+		s.progressMu.Lock()
+		res.Count = s.hashLife.Count()
+		res.Active = s.width * s.height //HashLife works out the whole world, however little of it is changing
+		res.Turn = s.hashLife.Turn()
+		s.progressMu.Unlock()
+		return
+	}
+	//Workers running by themselves could each be on a different turn
+	release := holdWorkers(s)
+	defer release()
+
+	s.progressMu.Lock()
+	if s.workerCount == 0 {
+		//The world has finished and its workers have been let go, which can happen while waiting for the hold
+		res.Count = s.world.Count()
+		res.Turn = s.worldTurn
+		s.progressMu.Unlock()
+		return
+	}
+	workerCount := s.workerCount
+	workerCountRes := make([]stubs.CountCellRes, workerCount)
+	workerDones := make([]*rpc.Call, workerCount)
+	for i := 0; i < workerCount; i++ {
+		workerDones[i] = s.workers[i].Go(stubs.WorkerCount, stubs.None{}, &workerCountRes[i], nil)
+	}
+	s.progressMu.Unlock()
+
+	count, active := 0, 0
+	for i := 0; i < workerCount; i++ {
+		<-workerDones[i].Done
+		if workerDones[i].Error != nil {
+			//A worker may have just crashed, ProgressAll will recover it so skip this count
+			println("Error in Broker calling Count on Worker:", workerDones[i].Error.Error())
+			res.Count = -1
+			return
+		}
+		count += workerCountRes[i].Count
+		active += workerCountRes[i].Active
+	}
+
+	res.Count = count
+	res.Active = active
+	res.Turn = workerCountRes[0].Turn
+	return
+}
+
+// Pause : Called by the controller to stop a running world between turns, returning once it has stopped
+func (b *Broker) Pause(req stubs.SessionReq, res *stubs.PauseRes) (err error) {
+	s, err := findSession(b, req.Session)
+	if err != nil {
+		return
+	}
+	//Workers running by themselves are held on the same turn, anything else waits for its loop to park between turns
+	var unhold func()
+	if runsAutonomously(s) {
+		unhold = holdWorkers(s)
+	}
+	s.stateMu.Lock()
+	if s.state != stateRunning {
+		state := s.state
+		s.stateMu.Unlock()
+		if unhold != nil {
+			unhold()
+		}
+		return errors.New(fmt.Sprint("can't pause a world that is ", state))
+	}
+	s.state = statePaused
+	s.unhold = unhold
+	s.stateChanged.Broadcast()
+	for unhold == nil && s.state == statePaused && !s.parked {
+		s.stateChanged.Wait()
+	}
+	if s.state != statePaused {
+		//Reached the final turn or quit before getting between turns
+		state := s.state
+		s.stateMu.Unlock()
+		return errors.New(fmt.Sprint("world ", state, " before it could pause"))
+	}
+	s.stateMu.Unlock()
+
+	s.progressMu.Lock()
+	res.Turn = s.currentTurn
+	s.progressMu.Unlock()
+	println("Paused on turn", res.Turn)
+	res.Output = fmt.Sprintf("Paused on turn %d", res.Turn)
+	return
+}
+
+// Resume : Called by the controller to let a paused world carry on
+func (b *Broker) Resume(req stubs.SessionReq, res *stubs.PauseRes) (err error) {
+	s, err := findSession(b, req.Session)
+	if err != nil {
+		return
+	}
+	s.stateMu.Lock()
+	if s.state != statePaused {
+		state := s.state
+		s.stateMu.Unlock()
+		return errors.New(fmt.Sprint("can't resume a world that is ", state))
+	}
+	s.state = stateRunning
+	unhold := s.unhold
+	s.unhold = nil
+	s.stateChanged.Broadcast()
+	s.stateMu.Unlock()
+	if unhold != nil {
+		unhold()
+	}
+
+	s.progressMu.Lock()
+	res.Turn = s.currentTurn
+	s.progressMu.Unlock()
+	println("Continuing from turn", res.Turn)
+	res.Output = fmt.Sprintf("Continuing from turn %d", res.Turn)
+	return
+}
+
+// Step : Called by the controller to run a paused world on by some turns, or up to a turn, and pause it again there.
+// Returns once it has paused again, or finished if that was the final turn
+func (b *Broker) Step(req stubs.StepReq, res *stubs.PauseRes) (err error) {
+	s, err := findSession(b, req.Session)
+	if err != nil {
+		return
+	}
+	s.stateMu.Lock()
+	if s.state != statePaused {
+		state := s.state
+		s.stateMu.Unlock()
+		return errors.New(fmt.Sprint("can only step a paused world, not one that is ", state))
+	}
+	//The loop is parked, so the turn won't change under us
+	until := s.currentTurn + req.Turns
+	if req.Until > 0 {
+		until = req.Until
+	}
+	if until <= s.currentTurn {
+		s.stateMu.Unlock()
+		return errors.New(fmt.Sprint("already on turn ", s.currentTurn, ", can't step to turn ", until))
+	}
+	if until > s.finalTurn {
+		until = s.finalTurn
+	}
+	s.state = stateStepping
+	s.stepUntil = until
+	unhold := s.unhold
+	s.unhold = nil
+	s.stateChanged.Broadcast()
+	s.stateMu.Unlock()
+	if unhold != nil {
+		unhold()
+	}
+
+	s.stateMu.Lock()
+	for s.state == stateStepping || (s.state == statePaused && !s.parked) {
+		s.stateChanged.Wait()
+	}
+	state := s.state
+	s.stateMu.Unlock()
+
+	s.progressMu.Lock()
+	res.Turn = s.currentTurn
+	s.progressMu.Unlock()
+	switch state {
+	case statePaused:
+		res.Output = fmt.Sprintf("Stepped to turn %d", res.Turn)
+	case stateFinished:
+		res.Output = fmt.Sprintf("Stepped to the final turn %d", res.Turn)
+	default:
+		return errors.New(fmt.Sprint("world ", state, " while stepping"))
+	}
+	println(res.Output)
+	return
+}
+
+func (b *Broker) Fetch(req stubs.SessionReq, res *stubs.WorldRes) (err error) {
+	s, err := findSession(b, req.Session)
+	if err != nil {
+		return
+	}
+	release := holdWorkers(s)
+	res.Turn, res.World, err = collectWorldFromWorkers(s)
+	release()
+	if err != nil {
+		return errors.New(fmt.Sprint("Error in Broker calling Fetch on Worker: ", err.Error()))
+	}
+	if s.printProgress {
+		println("World at fetch. Turn:", res.Turn)
+		util.VisualiseMatrix(res.World.Unpack(), s.width, s.height)
+	}
+	return
+}
+
+func collectWorldFromWorkers(s *session) (int, util.PackedWorld, error) {
+	s.progressMu.Lock()
+	if s.hashLife != nil {
+		defer s.progressMu.Unlock()
+		return s.hashLife.Turn(), s.hashLife.World(), nil
+	}
+	if s.workerCount == 0 {
+		//The workers have been let go, so the world is the one kept on the Broker
+		defer s.progressMu.Unlock()
+		return s.worldTurn, s.world, nil
+	}
+	workerCount := s.workerCount
+	workerDones := make([]*rpc.Call, workerCount)
+	workerFetchRes := make([]stubs.WorldRes, workerCount)
+	tileCorners := make([][2]int, workerCount)
+	for i := 0; i < workerCount; i++ {
+		workerDones[i] = s.workers[i].Go(stubs.WorkerFetch, stubs.None{}, &workerFetchRes[i], nil)
+		top, _, left, _ := tileBounds(s, i)
+		tileCorners[i] = [2]int{top, left}
+	}
+	s.progressMu.Unlock()
+
+	world := util.NewPackedWorld(s.width, s.height)
+	//ensure each fetch has completed, and put each tile back in its place
+	for i := 0; i < workerCount; i++ {
+		<-workerDones[i].Done
+		if workerDones[i].Error != nil {
+			return 0, world, errors.New(fmt.Sprint(workerDones[i].Error.Error()))
+		}
+		world.Paste(tileCorners[i][1], tileCorners[i][0], workerFetchRes[i].World)
+	}
+	return workerFetchRes[0].Turn, world, nil
+}
+
+func (b *Broker) Quit(req stubs.SessionReq, res *stubs.None) (err error) {
+	s, err := findSession(b, req.Session)
+	if err != nil {
+		return
+	}
+	//Quit all workers
+	println("Broker quit.")
+	quit(s) //stops progressAll loop, even if paused
+	return
+}
+
+// Kill : Called by the controller to shut the whole cluster down. Stops the world, returns it as it was on the turn it stopped,
+// has every Worker running it or registered exit, then has the Broker exit once the controller has hung up
+func (b *Broker) Kill(req stubs.SessionReq, res *stubs.KillRes) (err error) {
+	s, err := findSession(b, req.Session)
+	if err != nil {
+		return
+	}
+	//Other sessions' worlds would be lost along with the Workers running them
+	for _, other := range allSessions(b) {
+		other.runMu.Lock()
+		isRunning := other.isRunning
+		other.runMu.Unlock()
+		if other != s && isRunning {
+			return errors.New(fmt.Sprint("can't shut the cluster down while session ", other.id, " is running a world"))
+		}
+	}
+
+	//The world's workers are let go when it stops, so note them first
+	s.progressMu.Lock()
+	addresses := append([]string{}, s.workersAdr...)
+	s.progressMu.Unlock()
+	stopRun(s)
+	res.Turn, res.World, err = collectWorldFromWorkers(s)
+	if err != nil {
+		//Nothing to fetch the world from, so the last snapshot is the best there is
+		res.Turn, res.World = s.worldTurn, s.world
+	}
+
+	//Workers registered but not running the world go too
+	killing := make(map[string]bool)
+	for _, address := range addresses {
+		killing[address] = true
+	}
+	registered, _ := registeredWorkers(b)
+	for _, address := range registered {
+		if !killing[address] {
+			addresses = append(addresses, address)
+		}
+	}
+
+	results := make([]killResult, len(addresses))
+	var wg sync.WaitGroup
+	for i := range addresses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = killWorker(s, addresses[i])
+		}(i)
+	}
+	wg.Wait()
+	for i, address := range addresses {
+		switch results[i] {
+		case killExited:
+			res.Exited = append(res.Exited, address)
+		case killUnresponsive:
+			res.Unresponsive = append(res.Unresponsive, address)
+		case killUnreachable:
+			res.Unreachable = append(res.Unreachable, address)
+		}
+	}
+	retireWorkers(s)
+
+	res.Output = fmt.Sprintf("Stopped on turn %d, %d Worker(s) exited", res.Turn, len(res.Exited))
+	if len(res.Unresponsive) > 0 {
+		res.Output += fmt.Sprint(", no reply from ", res.Unresponsive)
+	}
+	if len(res.Unreachable) > 0 {
+		res.Output += fmt.Sprint(", couldn't connect to ", res.Unreachable)
+	}
+	println(res.Output)
+	s.killOnce.Do(func() {
+		close(s.killed)
+	})
+	return nil
+}
+
+// dial : connects to the Worker at address, over TCP unless the Broker was given another way to, 0 timeout waits as long as it takes
+func dial(b *Broker, address string, timeout time.Duration) (net.Conn, error) {
+	if b.dial != nil {
+		return b.dial(address, timeout)
+	}
+	return net.DialTimeout("tcp", address, timeout)
+}
+
+// dialWorker : an RPC client for the Worker at address
+func dialWorker(s *session, address string) (*rpc.Client, error) {
+	conn, err := dial(s.Broker, address, 0)
+	if err != nil {
+		return nil, err
+	}
+	return rpc.NewClient(conn), nil
+}
+
+// killResult : what became of a worker told to exit by Kill
+type killResult int
+
+const (
+	killExited       killResult = iota //confirmed and stopped listening
+	killUnresponsive                   //connected to, but didn't confirm or stop listening in time
+	killUnreachable                    //couldn't be connected to, it may have gone already or never have been there
+)
+
+// killWorker : dials the worker at address and has it exit, waiting up to the worker timeout
+// for it to confirm and stop listening
+func killWorker(s *session, address string) killResult {
+	deadline := time.Now().Add(s.workerTimeout)
+	conn, err := dial(s.Broker, address, s.workerTimeout)
+	if err != nil {
+		println("Error in Broker connecting to Worker", address, "to kill it:", err.Error())
+		return killUnreachable
+	}
+	client := rpc.NewClient(conn)
+	call := client.Go(stubs.WorkerKill, stubs.None{}, &stubs.None{}, nil)
+	select {
+	case <-call.Done:
+	case <-time.After(time.Until(deadline)):
+		_ = client.Close()
+		println("Worker", address, "didn't reply to Kill")
+		return killUnresponsive
+	}
+	_ = client.Close()
+	if call.Error != nil {
+		println("Error in Broker calling Kill on Worker", address+":", call.Error.Error())
+		return killUnresponsive
+	}
+
+	//The worker closes its listener before it exits, so it has gone once nothing answers on its address
+	for time.Now().Before(deadline) {
+		conn, err := dial(s.Broker, address, time.Until(deadline))
+		if err != nil {
+			return killExited
+		}
+		_ = conn.Close()
+		time.Sleep(50 * time.Millisecond)
+	}
+	return killUnresponsive
+}
@@ -0,0 +1,712 @@
+package broker_test
+
+import (
+	"fmt"
+	"net"
+	"net/rpc"
+	"strings"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/broker"
+	"uk.ac.bris.cs/gameoflife/internal/pipenet"
+	"uk.ac.bris.cs/gameoflife/internal/testutil"
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/util"
+	"uk.ac.bris.cs/gameoflife/worker"
+)
+
+// startBroker serves a Broker with settings and workers Workers over pipes,
+// returning a client for the Broker, the Workers' addresses and the network they are all served on.
+func startBroker(t *testing.T, settings broker.Settings, workers int) (*rpc.Client, []string, *pipenet.Network) {
+	network := pipenet.New()
+	settings.Dial = func(address string, timeout time.Duration) (net.Conn, error) {
+		return network.Dial(address)
+	}
+	err := network.Serve("broker", broker.New(settings))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var addresses []string
+	for i := 0; i < workers; i++ {
+		address := fmt.Sprint("worker", i+1)
+		w := worker.New(worker.Settings{Swar: true, Threads: 1, Dial: network.Dial})
+		err = network.Serve(address, w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addresses = append(addresses, address)
+		//A killed worker stops listening, as a process would by exiting
+		go func() {
+			select {
+			case <-worker.Killed(w):
+				network.Remove(address)
+			case <-network.Closed():
+			}
+		}()
+	}
+	conn, err := network.Dial("broker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rpc.NewClient(conn), addresses, network
+}
+
+// forever is more turns than any test waits for, so the world only stops when told to.
+const forever = 1 << 30
+
+// startWorld has the Broker run world for turns turns on Workers split as start asks,
+// returning the ProgressAll call that is done once the world finishes and the result it fills in.
+func startWorld(t *testing.T, b *rpc.Client, world util.PackedWorld, turns int, start stubs.BrokerStartReq) (*rpc.Call, *stubs.WorldRes) {
+	initReq := stubs.BrokerInitReq{Session: start.Session, World: world, Width: world.Width, Height: world.Height, Turns: turns, Rule: util.Conway}
+	err := b.Call(stubs.BrokerInit, initReq, &stubs.None{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Call(stubs.BrokerStart, start, &stubs.None{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := &stubs.WorldRes{}
+	return b.Go(stubs.BrokerProgressAll, stubs.SessionReq{Session: start.Session}, res, nil), res
+}
+
+// waitForTurn polls the Broker until the world in session is on turn or later, failing if it finishes first.
+func waitForTurn(t *testing.T, b *rpc.Client, session string, turn int, run *rpc.Call) stubs.BrokerStateRes {
+	for {
+		select {
+		case <-run.Done:
+			t.Fatal("world finished before turn ", turn)
+		default:
+		}
+		state := stubs.BrokerStateRes{}
+		err := b.Call(stubs.BrokerQueryState, stubs.SessionReq{Session: session}, &state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state.StillCalculating && state.Turn >= turn {
+			return state
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// finish waits for a ProgressAll call to return, failing if it takes more than a minute.
+func finish(t *testing.T, run *rpc.Call) error {
+	select {
+	case <-run.Done:
+		return run.Error
+	case <-time.After(time.Minute):
+		t.Fatal("world didn't finish")
+		return nil
+	}
+}
+
+// nextTurns works out turns more turns of world on a torus with testutil.NextTorus.
+func nextTurns(world util.PackedWorld, turns int) util.PackedWorld {
+	for i := 0; i < turns; i++ {
+		world = testutil.NextTorus(world, util.Conway)
+	}
+	return world
+}
+
+// checkFetch fetches the world in session and checks it against world worked out up to the turn it is on.
+func checkFetch(t *testing.T, b *rpc.Client, session string, world util.PackedWorld, turn int) {
+	res := stubs.WorldRes{}
+	err := b.Call(stubs.BrokerFetch, stubs.SessionReq{Session: session}, &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Turn != turn {
+		t.Errorf("fetched turn %d, expected %d", res.Turn, turn)
+	}
+	if !res.World.Equal(nextBounded(world, res.Turn, util.Torus)) {
+		t.Errorf("world fetched on turn %d differs from the reference", res.Turn)
+	}
+}
+
+// TestRecoverWorkers kills a Worker part way through 100 turns of the 512x512 image,
+// in bands and in tiles, with the Broker progressing the Workers a turn at a time and with them running by themselves.
+// The rest have to carry on from the last snapshot and still finish on the right world.
+func TestRecoverWorkers(t *testing.T) {
+	world := testutil.ReadFixture(t, "512x512x0")
+	expected := testutil.ReadFixture(t, "512x512x100")
+	for _, autonomous := range []bool{false, true} {
+		for _, tiled := range []bool{false, true} {
+			for _, depth := range []int{1, 4} {
+				name := fmt.Sprintf("autonomous=%v-tiled=%v-depth%d", autonomous, tiled, depth)
+				t.Run(name, func(t *testing.T) {
+					settings := broker.Defaults
+					settings.Autonomous = autonomous
+					settings.SnapshotInterval = 20
+					b, addresses, network := startBroker(t, settings, 4)
+					defer network.Close()
+
+					run, res := startWorld(t, b, world, 100, stubs.BrokerStartReq{WorkerCount: 4, WorkerAddresses: addresses, Tiled: tiled, HaloDepth: depth})
+					//Kill a worker once the world is past the first snapshot, so it has to be restored from one
+					waitForTurn(t, b, "", 20, run)
+					network.Hangup(addresses[1])
+					err := finish(t, run)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if res.Turn != 100 {
+						t.Errorf("finished on turn %d, expected 100", res.Turn)
+					}
+					if !res.World.Equal(expected) {
+						t.Error("world differs from the expected image after 100 turns")
+					}
+				})
+			}
+		}
+	}
+}
+
+// TestRecoverWorkersAllDead kills every Worker, which has to stop the world rather than leave it waiting for them.
+func TestRecoverWorkersAllDead(t *testing.T) {
+	b, addresses, network := startBroker(t, broker.Defaults, 2)
+	defer network.Close()
+	run, _ := startWorld(t, b, testutil.ReadFixture(t, "64x64x0"), forever, stubs.BrokerStartReq{WorkerAddresses: addresses})
+	waitForTurn(t, b, "", 1, run)
+	for _, address := range addresses {
+		network.Hangup(address)
+	}
+	err := finish(t, run)
+	if err == nil || !strings.Contains(err.Error(), "no Workers left") {
+		t.Fatalf("expected running out of Workers, got %v", err)
+	}
+}
+
+// TestRecoverWorkersPaused kills a Worker while the world is paused, which the Broker finds when it is stepped on.
+func TestRecoverWorkersPaused(t *testing.T) {
+	world := testutil.ReadFixture(t, "64x64x0")
+	for _, autonomous := range []bool{false, true} {
+		t.Run(fmt.Sprintf("autonomous=%v", autonomous), func(t *testing.T) {
+			settings := broker.Defaults
+			settings.Autonomous = autonomous
+			settings.SnapshotInterval = 10
+			b, addresses, network := startBroker(t, settings, 4)
+			defer network.Close()
+			run, _ := startWorld(t, b, world, forever, stubs.BrokerStartReq{WorkerAddresses: addresses})
+			waitForTurn(t, b, "", 1, run)
+
+			paused := stubs.PauseRes{}
+			err := b.Call(stubs.BrokerPause, stubs.SessionReq{}, &paused)
+			if err != nil {
+				t.Fatal(err)
+			}
+			network.Hangup(addresses[2])
+			stepped := stubs.PauseRes{}
+			err = b.Call(stubs.BrokerStep, stubs.StepReq{Turns: 20}, &stepped)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stepped.Turn != paused.Turn+20 {
+				t.Errorf("stepped from turn %d to %d, expected %d", paused.Turn, stepped.Turn, paused.Turn+20)
+			}
+			checkFetch(t, b, "", world, paused.Turn+20)
+
+			err = b.Call(stubs.BrokerQuit, stubs.SessionReq{}, &stubs.None{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = finish(t, run)
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// TestAttach has a controller leave a paused world running and another attach to it, then carry it on to the end.
+func TestAttach(t *testing.T) {
+	world := testutil.ReadFixture(t, "64x64x0")
+	first, addresses, network := startBroker(t, broker.Defaults, 2)
+	defer network.Close()
+	state := stubs.BrokerStateRes{}
+	err := first.Call(stubs.BrokerQueryState, stubs.SessionReq{}, &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.StillCalculating {
+		t.Fatal("a world is running before one was started")
+	}
+
+	run, _ := startWorld(t, first, world, 200, stubs.BrokerStartReq{WorkerAddresses: addresses})
+	waitForTurn(t, first, "", 1, run)
+	paused := stubs.PauseRes{}
+	err = first.Call(stubs.BrokerPause, stubs.SessionReq{}, &paused)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = first.Close()
+
+	conn, err := network.Dial("broker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := rpc.NewClient(conn)
+	err = second.Call(stubs.BrokerQueryState, stubs.SessionReq{}, &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.StillCalculating || !state.Paused || state.Turn != paused.Turn || state.Width != 64 || state.Height != 64 {
+		t.Fatalf("attached to %+v, expected the 64x64 world paused on turn %d", state, paused.Turn)
+	}
+	res := stubs.WorldRes{}
+	attached := second.Go(stubs.BrokerProgressAll, stubs.SessionReq{}, &res, nil)
+	err = second.Call(stubs.BrokerResume, stubs.SessionReq{}, &stubs.PauseRes{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = finish(t, attached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Turn != 200 || !res.World.Equal(nextTurns(world, 200)) {
+		t.Errorf("attached controller got turn %d, expected the world on turn 200", res.Turn)
+	}
+}
+
+// TestStreamFlips follows the flips streamed from a world to the end, in bands and tiles, a turn at a time and running
+// by itself. The world followed has to end up the same as the one the Broker finishes on,
+// and a controller too slow to keep up has to be sent keyframes to catch up with rather than hold the world up.
+func TestStreamFlips(t *testing.T) {
+	world := testutil.ReadFixture(t, "64x64x0")
+	expected := testutil.ReadFixture(t, "64x64x100")
+	for _, autonomous := range []bool{false, true} {
+		for _, tiled := range []bool{false, true} {
+			for _, slow := range []bool{false, true} {
+				t.Run(fmt.Sprintf("autonomous=%v-tiled=%v-slow=%v", autonomous, tiled, slow), func(t *testing.T) {
+					settings := broker.Defaults
+					settings.Autonomous = autonomous
+					if slow {
+						settings.StreamBuffer = 2
+						settings.StreamWait = time.Millisecond
+					}
+					b, addresses, network := startBroker(t, settings, 4)
+					defer network.Close()
+					err := b.Call(stubs.BrokerInit, stubs.BrokerInitReq{World: world, Width: 64, Height: 64, Turns: 100, Rule: util.Conway}, &stubs.None{})
+					if err != nil {
+						t.Fatal(err)
+					}
+					err = b.Call(stubs.BrokerStart, stubs.BrokerStartReq{WorkerAddresses: addresses, Tiled: tiled}, &stubs.None{})
+					if err != nil {
+						t.Fatal(err)
+					}
+					//Asking for a keyframe first, as a controller attaching to a world does
+					err = b.Call(stubs.BrokerSubscribe, stubs.SubscribeReq{Resync: true}, &stubs.None{})
+					if err != nil {
+						t.Fatal(err)
+					}
+					res := stubs.WorldRes{}
+					run := b.Go(stubs.BrokerProgressAll, stubs.SessionReq{}, &res, nil)
+
+					followed := util.NewPackedWorld(64, 64)
+					turn, keyframes := -1, 0
+					for finished := false; !finished; {
+						flips := stubs.FlipsRes{}
+						err = b.Call(stubs.BrokerFlips, stubs.SessionReq{}, &flips)
+						if err != nil {
+							t.Fatal(err)
+						}
+						for _, diff := range flips.Diffs {
+							if diff.Keyframe {
+								keyframes++
+								followed = util.NewPackedWorld(64, 64)
+								for _, index := range util.DecodeFlips(diff.Cells) {
+									followed.Set(index%64, index/64, true)
+								}
+							} else {
+								if turn == -1 || diff.Turn != turn+1 {
+									t.Fatalf("flips for turn %d followed turn %d", diff.Turn, turn)
+								}
+								for _, index := range util.DecodeFlips(diff.Cells) {
+									followed.Set(index%64, index/64, !followed.Alive(index%64, index/64))
+								}
+							}
+							turn = diff.Turn
+						}
+						finished = flips.Finished
+						if slow {
+							time.Sleep(5 * time.Millisecond)
+						}
+					}
+
+					err = finish(t, run)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if slow {
+						//Even the last keyframe can be given up on, leaving the controller the world ProgressAll returns
+						if !followed.Equal(nextTurns(world, turn)) {
+							t.Errorf("world followed to turn %d differs from the reference", turn)
+						}
+						if keyframes < 2 {
+							t.Error("a controller that couldn't keep up wasn't sent a keyframe to catch up with")
+						}
+					} else if turn != 100 || !followed.Equal(res.World) || !followed.Equal(expected) {
+						t.Errorf("followed the flips to turn %d, expected the world on turn 100", turn)
+					}
+				})
+			}
+		}
+	}
+}
+
+// nextBounded works out turns more turns of world on boundary, the whole world at once with util.NextStateSWAR.
+func nextBounded(world util.PackedWorld, turns int, boundary util.Boundary) util.PackedWorld {
+	padded := util.NewPackedWorld(world.Width+2, world.Height+2)
+	padded.Paste(1, 1, world)
+	next := util.NewPackedWorld(padded.Width, padded.Height)
+	for i := 0; i < turns; i++ {
+		util.FillHalo(padded, 1, boundary)
+		util.NextStateSWAR(padded, next, util.Conway)
+		padded, next = next, padded
+	}
+	return padded.Block(1, 1, world.Width, world.Height)
+}
+
+// TestTiles splits a world into tiles between different numbers of Workers on every boundary,
+// swapping halos every turn and every few turns, which has to give the same world as working it out whole.
+func TestTiles(t *testing.T) {
+	world := testutil.ReadFixture(t, "64x64x0")
+	for _, boundary := range []util.Boundary{util.Torus, util.DeadBorder, util.Mirror, util.KleinBottle} {
+		expected := nextBounded(world, 20, boundary)
+		for _, workers := range []int{2, 4, 6, 9} {
+			for _, depth := range []int{1, 3} {
+				t.Run(fmt.Sprintf("%v-%d-depth%d", boundary, workers, depth), func(t *testing.T) {
+					b, addresses, network := startBroker(t, broker.Defaults, workers)
+					defer network.Close()
+					err := b.Call(stubs.BrokerInit, stubs.BrokerInitReq{World: world, Width: 64, Height: 64, Turns: 20, Rule: util.Conway, Boundary: boundary}, &stubs.None{})
+					if err != nil {
+						t.Fatal(err)
+					}
+					err = b.Call(stubs.BrokerStart, stubs.BrokerStartReq{WorkerAddresses: addresses, Tiled: true, HaloDepth: depth}, &stubs.None{})
+					if err != nil {
+						t.Fatal(err)
+					}
+					res := stubs.WorldRes{}
+					err = b.Call(stubs.BrokerProgressAll, stubs.SessionReq{}, &res)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !res.World.Equal(expected) {
+						t.Error("tiled world differs from the whole world after 20 turns")
+					}
+				})
+			}
+		}
+	}
+}
+
+// TestRebalance has the Broker move rows between Workers every few turns, one of them working a cell at a time
+// so much slower than the rest, and checks moving the rows doesn't change the world.
+func TestRebalance(t *testing.T) {
+	world := testutil.ReadFixture(t, "512x512x0")
+	expected := testutil.ReadFixture(t, "512x512x100")
+	for _, autonomous := range []bool{false, true} {
+		for _, depth := range []int{1, 3} {
+			t.Run(fmt.Sprintf("autonomous=%v-depth%d", autonomous, depth), func(t *testing.T) {
+				settings := broker.Defaults
+				settings.Autonomous = autonomous
+				settings.BalanceEvery = 5
+				b, addresses, network := startBroker(t, settings, 3)
+				defer network.Close()
+				err := network.Serve("slow", worker.New(worker.Settings{Threads: 1, Dial: network.Dial}))
+				if err != nil {
+					t.Fatal(err)
+				}
+				run, res := startWorld(t, b, world, 100, stubs.BrokerStartReq{WorkerAddresses: append(addresses, "slow"), HaloDepth: depth})
+				err = finish(t, run)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if res.Turn != 100 || !res.World.Equal(expected) {
+					t.Errorf("finished on turn %d, expected the image on turn 100", res.Turn)
+				}
+			})
+		}
+	}
+}
+
+// TestScaleWorkers adds a Worker to a running world then takes Workers away again, including the one with the top band,
+// in bands and tiles, a turn at a time and running by themselves. The world has to carry on as if nothing happened.
+func TestScaleWorkers(t *testing.T) {
+	world := testutil.ReadFixture(t, "64x64x0")
+	for _, autonomous := range []bool{false, true} {
+		for _, tiled := range []bool{false, true} {
+			t.Run(fmt.Sprintf("autonomous=%v-tiled=%v", autonomous, tiled), func(t *testing.T) {
+				settings := broker.Defaults
+				settings.Autonomous = autonomous
+				b, addresses, network := startBroker(t, settings, 4)
+				defer network.Close()
+				run, _ := startWorld(t, b, world, forever, stubs.BrokerStartReq{WorkerAddresses: addresses[:3], Tiled: tiled, HaloDepth: 2})
+				waitForTurn(t, b, "", 1, run)
+
+				scales := []struct {
+					method  string
+					address string
+					workers int
+				}{
+					{stubs.BrokerAddWorker, addresses[3], 4},
+					{stubs.BrokerRemoveWorker, "", 3},
+					{stubs.BrokerRemoveWorker, addresses[0], 2},
+				}
+				for _, scale := range scales {
+					res := stubs.ScaleRes{}
+					err := b.Call(scale.method, stubs.ScaleReq{Address: scale.address}, &res)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if res.WorkerCount != scale.workers || (scale.address != "" && res.Address != scale.address) {
+						t.Errorf("%s %q left %d Workers after changing %s, expected %d", scale.method, scale.address, res.WorkerCount, res.Address, scale.workers)
+					}
+				}
+				err := b.Call(stubs.BrokerAddWorker, stubs.ScaleReq{Address: addresses[1]}, &stubs.ScaleRes{})
+				if err == nil {
+					t.Error("added a Worker already running the world")
+				}
+
+				paused := stubs.PauseRes{}
+				err = b.Call(stubs.BrokerPause, stubs.SessionReq{}, &paused)
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkFetch(t, b, "", world, paused.Turn)
+				err = b.Call(stubs.BrokerQuit, stubs.SessionReq{}, &stubs.None{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = finish(t, run)
+				if err != nil {
+					t.Fatal(err)
+				}
+			})
+		}
+	}
+}
+
+// TestPauseResume pauses a world, checks it stays on the turn it paused on, then lets it carry on,
+// a turn at a time and running by itself. Pausing or resuming a world that already is has to be refused.
+func TestPauseResume(t *testing.T) {
+	world := testutil.ReadFixture(t, "64x64x0")
+	for _, autonomous := range []bool{false, true} {
+		t.Run(fmt.Sprintf("autonomous=%v", autonomous), func(t *testing.T) {
+			settings := broker.Defaults
+			settings.Autonomous = autonomous
+			b, addresses, network := startBroker(t, settings, 3)
+			defer network.Close()
+			run, _ := startWorld(t, b, world, forever, stubs.BrokerStartReq{WorkerAddresses: addresses})
+			waitForTurn(t, b, "", 1, run)
+			err := b.Call(stubs.BrokerResume, stubs.SessionReq{}, &stubs.PauseRes{})
+			if err == nil {
+				t.Error("resumed a world that wasn't paused")
+			}
+
+			paused := stubs.PauseRes{}
+			err = b.Call(stubs.BrokerPause, stubs.SessionReq{}, &paused)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = b.Call(stubs.BrokerPause, stubs.SessionReq{}, &stubs.PauseRes{})
+			if err == nil {
+				t.Error("paused a world that was already paused")
+			}
+			time.Sleep(20 * time.Millisecond)
+			state := stubs.BrokerStateRes{}
+			err = b.Call(stubs.BrokerQueryState, stubs.SessionReq{}, &state)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !state.Paused || state.Turn != paused.Turn {
+				t.Errorf("world paused on turn %d is on turn %d, paused %v", paused.Turn, state.Turn, state.Paused)
+			}
+			checkFetch(t, b, "", world, paused.Turn)
+
+			resumed := stubs.PauseRes{}
+			err = b.Call(stubs.BrokerResume, stubs.SessionReq{}, &resumed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resumed.Turn != paused.Turn {
+				t.Errorf("resumed from turn %d, expected %d", resumed.Turn, paused.Turn)
+			}
+			waitForTurn(t, b, "", paused.Turn+10, run)
+			err = b.Call(stubs.BrokerQuit, stubs.SessionReq{}, &stubs.None{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = finish(t, run)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = b.Call(stubs.BrokerPause, stubs.SessionReq{}, &stubs.PauseRes{})
+			if err == nil {
+				t.Error("paused a world that had finished")
+			}
+		})
+	}
+}
+
+// TestStep steps a paused world on by turns and up to turns, checking the world after each step,
+// then steps it past the final turn, which has to stop on the final turn and finish the world.
+func TestStep(t *testing.T) {
+	world := testutil.ReadFixture(t, "64x64x0")
+	for _, autonomous := range []bool{false, true} {
+		t.Run(fmt.Sprintf("autonomous=%v", autonomous), func(t *testing.T) {
+			settings := broker.Defaults
+			settings.Autonomous = autonomous
+			b, addresses, network := startBroker(t, settings, 3)
+			defer network.Close()
+			run, res := startWorld(t, b, world, 5000, stubs.BrokerStartReq{WorkerAddresses: addresses, HaloDepth: 2})
+			waitForTurn(t, b, "", 1, run)
+			err := b.Call(stubs.BrokerStep, stubs.StepReq{Turns: 1}, &stubs.PauseRes{})
+			if err == nil {
+				t.Error("stepped a world that wasn't paused")
+			}
+			paused := stubs.PauseRes{}
+			err = b.Call(stubs.BrokerPause, stubs.SessionReq{}, &paused)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			turn := paused.Turn
+			for _, step := range []stubs.StepReq{{Turns: 1}, {Turns: 10}, {Until: paused.Turn + 20}} {
+				stepped := stubs.PauseRes{}
+				err = b.Call(stubs.BrokerStep, step, &stepped)
+				if err != nil {
+					t.Fatal(err)
+				}
+				turn += step.Turns
+				if step.Until > 0 {
+					turn = step.Until
+				}
+				if stepped.Turn != turn {
+					t.Errorf("stepped to turn %d, expected %d", stepped.Turn, turn)
+				}
+				checkFetch(t, b, "", world, turn)
+			}
+			err = b.Call(stubs.BrokerStep, stubs.StepReq{Until: turn}, &stubs.PauseRes{})
+			if err == nil {
+				t.Error("stepped to the turn the world was already on")
+			}
+
+			stepped := stubs.PauseRes{}
+			err = b.Call(stubs.BrokerStep, stubs.StepReq{Until: 6000}, &stepped)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stepped.Turn != 5000 || !strings.Contains(stepped.Output, "final turn") {
+				t.Errorf("stepping past the final turn stopped on turn %d: %s", stepped.Turn, stepped.Output)
+			}
+			err = finish(t, run)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Turn != 5000 || !res.World.Equal(nextBounded(world, 5000, util.Torus)) {
+				t.Errorf("finished on turn %d, expected the world on turn 5000", res.Turn)
+			}
+		})
+	}
+}
+
+// TestKill shuts the cluster down while a world is running, which has to return the world on the turn it stopped
+// and have every Worker running it or registered exit. A registered Worker that has gone has to be reported as unreachable.
+func TestKill(t *testing.T) {
+	world := testutil.ReadFixture(t, "64x64x0")
+	for _, autonomous := range []bool{false, true} {
+		t.Run(fmt.Sprintf("autonomous=%v", autonomous), func(t *testing.T) {
+			settings := broker.Defaults
+			settings.Autonomous = autonomous
+			settings.WorkerTimeout = time.Second
+			b, addresses, network := startBroker(t, settings, 4)
+			defer network.Close()
+			for _, address := range []string{addresses[3], "gone"} {
+				err := b.Call(stubs.BrokerRegister, stubs.RegisterReq{Address: address, Capacity: 1}, &stubs.None{})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			run, _ := startWorld(t, b, world, forever, stubs.BrokerStartReq{WorkerAddresses: addresses[:3]})
+			waitForTurn(t, b, "", 1, run)
+
+			res := stubs.KillRes{}
+			err := b.Call(stubs.BrokerKill, stubs.SessionReq{}, &res)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Turn < 1 || !res.World.Equal(nextBounded(world, res.Turn, util.Torus)) {
+				t.Errorf("killed on turn %d with a world that differs from the reference", res.Turn)
+			}
+			if fmt.Sprint(res.Exited) != fmt.Sprint(addresses) || fmt.Sprint(res.Unreachable) != "[gone]" || len(res.Unresponsive) > 0 {
+				t.Errorf("Workers exited %v, unreachable %v, unresponsive %v, expected %v to exit and gone to be unreachable",
+					res.Exited, res.Unreachable, res.Unresponsive, addresses)
+			}
+			for _, address := range addresses {
+				_, err = network.Dial(address)
+				if err == nil {
+					t.Errorf("%s is still listening after being killed", address)
+				}
+			}
+			err = finish(t, run)
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// TestSessions runs two worlds at once in their own sessions on a share each of the registered Workers,
+// neither of which may disturb the other, and checks the cluster can't be killed from a third while one is running.
+func TestSessions(t *testing.T) {
+	b, addresses, network := startBroker(t, broker.Defaults, 4)
+	defer network.Close()
+	for _, address := range addresses {
+		err := b.Call(stubs.BrokerRegister, stubs.RegisterReq{Address: address, Capacity: 1}, &stubs.None{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := b.Call(stubs.BrokerInit, stubs.BrokerInitReq{Session: "not a session"}, &stubs.None{})
+	if err == nil {
+		t.Error("opened a session with spaces in its ID")
+	}
+
+	big := testutil.ReadFixture(t, "64x64x0")
+	bigRun, _ := startWorld(t, b, big, forever, stubs.BrokerStartReq{Session: "big"})
+	waitForTurn(t, b, "big", 1, bigRun)
+	small := testutil.ReadFixture(t, "16x16x0")
+	smallRun, smallRes := startWorld(t, b, small, 100, stubs.BrokerStartReq{Session: "small"})
+	err = finish(t, smallRun)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if smallRes.Turn != 100 || !smallRes.World.Equal(testutil.ReadFixture(t, "16x16x100")) {
+		t.Errorf("small session finished on turn %d, expected the image on turn 100", smallRes.Turn)
+	}
+
+	err = b.Call(stubs.BrokerInit, stubs.BrokerInitReq{Session: "other", World: small, Width: 16, Height: 16, Turns: 100, Rule: util.Conway}, &stubs.None{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Call(stubs.BrokerKill, stubs.SessionReq{Session: "other"}, &stubs.KillRes{})
+	if err == nil {
+		t.Error("killed the cluster from one session while another was running a world")
+	}
+
+	paused := stubs.PauseRes{}
+	err = b.Call(stubs.BrokerPause, stubs.SessionReq{Session: "big"}, &paused)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFetch(t, b, "big", big, paused.Turn)
+	err = b.Call(stubs.BrokerQuit, stubs.SessionReq{Session: "big"}, &stubs.None{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = finish(t, bigRun)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,124 @@
+package checkpoint
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// Checkpoint is a saved world along with everything needed to carry on simulating it.
+type Checkpoint struct {
+	Turn     int
+	Width    int
+	Height   int
+	Rule     string
+	Boundary string
+	World    util.PackedWorld
+}
+
+// Save writes the checkpoint to path as a PGM image, with the turn, rule and boundary stored in comments.
+// The world is unpacked to a byte per cell a row at a time as it is written.
+// The file is written to a temporary file first so a crash never leaves a half written checkpoint.
+func Save(path string, c Checkpoint) error {
+	if dir := filepath.Dir(path); dir != "." {
+		_ = os.MkdirAll(dir, os.ModePerm)
+	}
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	_, _ = fmt.Fprintf(writer, "P5\n# turn %d\n# rule %s\n# boundary %s\n%d %d\n255\n", c.Turn, c.Rule, c.Boundary, c.Width, c.Height)
+	row := make([]byte, c.Width)
+	for y := 0; y < c.Height; y++ {
+		util.UnpackRow(row, c.World.Rows[y])
+		_, err = writer.Write(row)
+		if err != nil {
+			_ = file.Close()
+			return err
+		}
+	}
+	err = writer.Flush()
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Load reads a checkpoint previously written by Save.
+func Load(path string) (Checkpoint, error) {
+	c := Checkpoint{}
+	file, err := os.Open(path)
+	if err != nil {
+		return c, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	//Header is "P5", width, height and maxval, with the turn, rule and boundary in comments between them
+	var fields []string
+	for len(fields) < 4 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return c, errors.New(fmt.Sprint("Error reading checkpoint header: ", err.Error()))
+		}
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") {
+			comment := strings.Fields(strings.TrimPrefix(line, "#"))
+			if len(comment) == 2 && comment[0] == "turn" {
+				c.Turn, err = strconv.Atoi(comment[1])
+				if err != nil {
+					return c, errors.New(fmt.Sprint("Bad turn in checkpoint: ", comment[1]))
+				}
+			} else if len(comment) == 2 && comment[0] == "rule" {
+				c.Rule = comment[1]
+			} else if len(comment) == 2 && comment[0] == "boundary" {
+				c.Boundary = comment[1]
+			}
+			continue
+		}
+		fields = append(fields, strings.Fields(line)...)
+	}
+
+	if fields[0] != "P5" {
+		return c, errors.New("checkpoint is not a pgm file")
+	}
+	c.Width, err = strconv.Atoi(fields[1])
+	if err != nil {
+		return c, errors.New("bad width in checkpoint")
+	}
+	c.Height, err = strconv.Atoi(fields[2])
+	if err != nil {
+		return c, errors.New("bad height in checkpoint")
+	}
+	if fields[3] != "255" {
+		return c, errors.New("incorrect maxval/bit depth in checkpoint")
+	}
+
+	c.World = util.NewPackedWorld(c.Width, c.Height)
+	row := make([]byte, c.Width)
+	for y := 0; y < c.Height; y++ {
+		_, err = io.ReadFull(reader, row)
+		if err != nil {
+			return c, errors.New(fmt.Sprint("Error reading checkpoint world: ", err.Error()))
+		}
+		util.PackRow(c.World.Rows[y], row)
+	}
+	return c, nil
+}
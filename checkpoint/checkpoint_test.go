@@ -0,0 +1,30 @@
+package checkpoint
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestSaveLoad checks a checkpoint survives being written to disk and read back.
+func TestSaveLoad(t *testing.T) {
+	world := [][]byte{
+		{0, 255, 0, 0},
+		{0, 0, 255, 0},
+		{255, 255, 255, 0},
+	}
+	saved := Checkpoint{Turn: 1234, Width: 4, Height: 3, Rule: "B3/S23", Boundary: "klein", World: util.PackWorld(world, 4, 3)}
+	path := filepath.Join(t.TempDir(), "checkpoint.pgm")
+	if err := Save(path, saved); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(saved, loaded) {
+		t.Errorf("loaded %+v, expected %+v", loaded, saved)
+	}
+}
@@ -1,6 +1,7 @@
 package gol
 
 import (
+	"errors"
 	"fmt"
 	"net/rpc"
 	"strings"
@@ -36,80 +37,135 @@ type turnCount struct {
 
 // distributor divides the work between workers and interacts with other goroutines.
 func distributor(p Params, c distributorChannels, keyPresses <-chan rune) {
-	//Activate IO to output world:
-	c.ioCommand <- ioInput
-	c.ioFilename <- fmt.Sprintf("%dx%d", p.ImageHeight, p.ImageWidth)
-
-	//Create 2D slice and store received world in it, also send live cells down cell flipped
-	world := make([][]byte, p.ImageHeight)
-	for y := 0; y < p.ImageHeight; y++ {
-		world[y] = make([]byte, p.ImageWidth)
-		for x := 0; x < p.ImageWidth; x++ {
-			cell := <-c.ioInput
-			if cell == 255 {
-				c.events <- CellFlipped{0, util.Cell{X: x, Y: y}}
-			}
-			world[y][x] = cell
+	//Connect to broker, or run one in this process
+	var broker brokerClient
+	var err error
+	if p.InProcess {
+		workers := p.Threads
+		if workers < 1 {
+			workers = 1
 		}
+		var local *inProcessBroker
+		local, err = startInProcess(workers, *p.InProcessBroker)
+		if err != nil {
+			println("Error in distributor starting Broker in process:", err.Error())
+			close(c.events)
+			return
+		}
+		//Only the Workers served in process can be reached
+		p.WorkerAddresses = strings.Join(local.workerAddresses, ",")
+		broker = local
+	} else {
+		var client *rpc.Client
+		client, err = rpc.Dial("tcp", p.BrokerAddress)
+		if err != nil {
+			println("Error in distributor connecting to Broker:", err.Error())
+			close(c.events)
+			return
+		}
+		broker = client
 	}
-
-	//Connect to broker
-	broker, err := rpc.Dial("tcp", p.BrokerAddress)
-	if err != nil {
-		println("Error in distributor connecting to Broker:", err.Error())
-		close(c.events)
-		return
-	}
-	defer func(broker *rpc.Client) {
+	defer func(broker brokerClient) {
 		err := broker.Close()
 		if err != nil {
 			println("Error in distributor closing Broker:", err.Error())
 		}
 	}(broker)
 
-	//Init broker
-	err = broker.Call(stubs.BrokerInit, stubs.BrokerInitReq{
-		World:         world,
-		Width:         p.ImageWidth,
-		Height:        p.ImageHeight,
-		Turns:         p.Turns,
-		PrintProgress: p.PrintProgress,
-	},
-		&stubs.None{},
-	)
+	//See if a previous controller left a world running
+	stateResponse := stubs.BrokerStateRes{}
+	err = broker.Call(stubs.BrokerQueryState, stubs.SessionReq{Session: p.Session}, &stateResponse)
 	if err != nil {
-		println("Error in distributor calling Init on Broker:", err.Error())
+		println("Error in distributor calling QueryState on Broker:", err.Error())
 		close(c.events)
 		return
 	}
+	attach := p.Attach && stateResponse.StillCalculating
+	if stateResponse.StillCalculating && !attach {
+		println("Replacing world already running on Broker:", stateResponse.Details)
+	}
 
-	//Start broker (communicate with workers)
-	workerAddresses := strings.Split(p.WorkerAddresses, ",")
-	err = broker.Call(stubs.BrokerStart, stubs.BrokerStartReq{
-		WorkerCount:     p.Threads,
-		WorkerAddresses: workerAddresses,
-	}, &stubs.None{},
-	)
-
+	var world util.PackedWorld
+	if attach {
+		world, err = attachBroker(broker, p, c, stateResponse)
+	} else if p.Resume {
+		world, err = restoreBroker(broker, p, c)
+	} else {
+		world, err = initBroker(broker, p, c)
+	}
 	if err != nil {
-		println("Error in distributor calling Start on Broker:", err.Error())
+		println(err.Error())
 		close(c.events)
 		return
 	}
 
-	//Progress broker
+	if !attach {
+		//Start broker (communicate with workers)
+		//No addresses means use the Workers registered with the Broker
+		var workerAddresses []string
+		if p.WorkerAddresses != "" {
+			workerAddresses = strings.Split(p.WorkerAddresses, ",")
+		}
+		err = broker.Call(stubs.BrokerStart, stubs.BrokerStartReq{
+			Session:         p.Session,
+			WorkerCount:     p.Threads,
+			WorkerAddresses: workerAddresses,
+			Tiled:           p.Tiled,
+			HaloDepth:       p.HaloDepth,
+		}, &stubs.None{},
+		)
+
+		if err != nil {
+			println("Error in distributor calling Start on Broker:", err.Error())
+			close(c.events)
+			return
+		}
+	}
+
+	//Stream the cells flipped each turn so the visualisation animates
+	stopStream := make(chan struct{})
+	streamFinished := make(chan struct{})
+	if p.NoStream {
+		close(streamFinished)
+	} else {
+		err = broker.Call(stubs.BrokerSubscribe, stubs.SubscribeReq{Session: p.Session, Resync: attach}, &stubs.None{})
+		if err != nil {
+			println("Error in distributor calling Subscribe on Broker:", err.Error())
+			close(c.events)
+			return
+		}
+		go streamFlips(broker, world, p, c, stopStream, streamFinished)
+	}
+	//Stops the stream early, it must not send any more events once c.events is closed
+	endStream := func() {
+		close(stopStream)
+		<-streamFinished
+	}
+
+	//Progress broker, if attached this just waits for the running world to finish
+	progressResponse := new(stubs.WorldRes)
 	doneProgressing := broker.Go(stubs.BrokerProgressAll,
-		stubs.None{},
-		&stubs.None{}, nil)
+		stubs.SessionReq{Session: p.Session},
+		progressResponse, nil)
 
+	//A world attached to may have been left paused by the controller before
+	paused := attach && stateResponse.Paused
+	if paused {
+		c.events <- StateChange{stateResponse.Turn, Paused}
+	}
+
+	stepCount := 0 //digits typed before n or u
 	timer := time.NewTimer(2 * time.Second)
 	killed := false
+	killResponse := new(stubs.KillRes)
+	detached := false
 	done := false
 	for !done {
 		select {
 		case <-doneProgressing.Done:
 			if doneProgressing.Error != nil {
 				println("Error in distributor calling ProgressAll on Broker:", doneProgressing.Error.Error())
+				endStream()
 				close(c.events)
 				return
 			}
@@ -118,63 +174,143 @@ func distributor(p Params, c distributorChannels, keyPresses <-chan rune) {
 		case <-timer.C:
 			timer.Reset(2 * time.Second)
 			countResponse := new(stubs.CountCellRes)
-			err := broker.Call(stubs.BrokerCount, stubs.None{}, &countResponse)
+			err := broker.Call(stubs.BrokerCount, stubs.SessionReq{Session: p.Session}, countResponse)
 			if err != nil {
 				println("Error in distributor calling Count on Broker:", err.Error())
+				endStream()
 				close(c.events)
 				return
 			}
 			if countResponse.Count != -1 {
-				c.events <- AliveCellsCount{countResponse.Turn, countResponse.Count}
+				c.events <- AliveCellsCount{
+					CompletedTurns: countResponse.Turn,
+					CellsCount:     countResponse.Count,
+					ActiveFraction: float64(countResponse.Active) / float64(p.ImageWidth*p.ImageHeight),
+				}
 			}
 			break
 		case key := <-keyPresses:
 			switch key {
 			case 's':
 				worldResponse := new(stubs.WorldRes)
-				err := broker.Call(stubs.BrokerFetch, stubs.None{}, &worldResponse)
+				err := broker.Call(stubs.BrokerFetch, stubs.SessionReq{Session: p.Session}, worldResponse)
 				if err != nil {
 					println("Error in distributor calling Fetch on Broker:", err.Error())
+					endStream()
 					close(c.events)
 					return
 				}
 				sendWorldToPGM(worldResponse.World, worldResponse.Turn, p, c)
 				break
 			case 'p':
+				//Pause and Resume return once the world has stopped or carried on, so the state change is on the right turn
+				pauseMethod, newState := stubs.BrokerPause, Paused
+				if paused {
+					pauseMethod, newState = stubs.BrokerResume, Executing
+				}
 				pauseResponse := new(stubs.PauseRes)
-				err := broker.Call(stubs.BrokerPause, stubs.None{}, &pauseResponse)
+				err := broker.Call(pauseMethod, stubs.SessionReq{Session: p.Session}, pauseResponse)
 				if err != nil {
-					println("Error in distributor calling Pause on Broker:", err.Error())
+					println("Error in distributor calling", pauseMethod, "on Broker:", err.Error())
+					break
 				}
 				println(pauseResponse.Output)
+				paused = !paused
+				c.events <- StateChange{pauseResponse.Turn, newState}
 				break
 			case 'q':
-				err := broker.Call(stubs.BrokerQuit, stubs.None{}, &stubs.None{})
+				//Leave the world running on the broker so another controller can attach to it later
+				if p.InProcess {
+					println("Quiting...")
+				} else {
+					println("Quiting, world left running on Broker...")
+				}
+				detached = true
+				done = true
+				break
+			case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+				stepCount = stepCount*10 + int(key-'0')
+				break
+			case 'n', 'u':
+				//n steps a paused world on by the number typed before it, or one turn, u runs it up to that turn
+				stepReq := stubs.StepReq{Session: p.Session, Turns: 1}
+				if key == 'n' && stepCount > 0 {
+					stepReq.Turns = stepCount
+				} else if key == 'u' {
+					stepReq = stubs.StepReq{Session: p.Session, Until: stepCount}
+				}
+				stepCount = 0
+				if !paused {
+					println("Pause with p before stepping")
+					break
+				}
+				stepResponse := new(stubs.PauseRes)
+				err := broker.Call(stubs.BrokerStep, stepReq, stepResponse)
+				if err != nil {
+					println("Error in distributor calling Step on Broker:", err.Error())
+					break
+				}
+				println(stepResponse.Output)
+				if p.NoStream {
+					//Nothing streams the steps, so show where they got to
+					world, err = showStep(broker, world, p, c)
+					if err != nil {
+						println(err.Error())
+					}
+				}
+				break
+			case '+', '-':
+				//Scale the running world up or down by one worker, the Broker picks which
+				scaleMethod, action := stubs.BrokerAddWorker, "Added"
+				if key == '-' {
+					scaleMethod, action = stubs.BrokerRemoveWorker, "Removed"
+				}
+				scaleResponse := new(stubs.ScaleRes)
+				err := broker.Call(scaleMethod, stubs.ScaleReq{Session: p.Session}, scaleResponse)
 				if err != nil {
-					println("Error in distributor calling Quit on Broker:", err.Error())
+					println("Error in distributor calling", scaleMethod, "on Broker:", err.Error())
+					break
 				}
-				println("Quiting...")
+				println(action, "Worker", scaleResponse.Address, "now running on", scaleResponse.WorkerCount, "Workers")
 				break
 			case 'k':
-				err := broker.Call(stubs.BrokerQuit, stubs.None{}, &stubs.None{})
+				//Shuts down the broker and every worker, getting back the world they stopped on to save
+				println("Killing...")
+				err := broker.Call(stubs.BrokerKill, stubs.SessionReq{Session: p.Session}, killResponse)
 				if err != nil {
 					println("Error in distributor calling Kill on Broker:", err.Error())
+					break
 				}
+				println(killResponse.Output)
 				killed = true
+				done = true
 				break
 			}
 		}
 	}
 
-	worldResponse := stubs.WorldRes{}
-	err = broker.Call(stubs.BrokerFetch, stubs.None{}, &worldResponse)
-	if err != nil {
-		println("Error in distributor calling Fetch on Broker:", err.Error())
+	if detached {
+		if !p.NoStream {
+			err = broker.Call(stubs.BrokerUnsubscribe, stubs.SessionReq{Session: p.Session}, &stubs.None{})
+			if err != nil {
+				println("Error in distributor calling Unsubscribe on Broker:", err.Error())
+			}
+		}
+		endStream()
+	} else {
+		//Make sure every turn has been shown before the final one
+		<-streamFinished
 	}
 
+	//A finished world comes back from ProgressAll, as the Broker forgets the session once it is done
+	worldResponse := *progressResponse
 	if killed {
-		println("Killing...")
-		_ = broker.Go(stubs.BrokerKill, stubs.None{}, &stubs.None{}, nil)
+		worldResponse = stubs.WorldRes{World: killResponse.World, Turn: killResponse.Turn}
+	} else if detached {
+		err = broker.Call(stubs.BrokerFetch, stubs.SessionReq{Session: p.Session}, &worldResponse)
+		if err != nil {
+			println("Error in distributor calling Fetch on Broker:", err.Error())
+		}
 	}
 
 	world = worldResponse.World
@@ -194,12 +330,180 @@ func distributor(p Params, c distributorChannels, keyPresses <-chan rune) {
 	close(c.events)
 }
 
+//Reads the starting world from images/WxH.pgm and passes it to the broker
+func initBroker(broker brokerClient, p Params, c distributorChannels) (util.PackedWorld, error) {
+	//Activate IO to output world:
+	c.ioCommand <- ioInput
+	c.ioFilename <- fmt.Sprintf("%dx%d", p.ImageHeight, p.ImageWidth)
+
+	//Pack received world one bit per cell, also send live cells down cell flipped
+	world := receivePackedWorld(c.ioInput, p.ImageWidth, p.ImageHeight, func(cell util.Cell) {
+		c.events <- CellFlipped{0, cell}
+	})
+
+	//Init broker
+	err := broker.Call(stubs.BrokerInit, stubs.BrokerInitReq{
+		Session:       p.Session,
+		World:         world,
+		Width:         p.ImageWidth,
+		Height:        p.ImageHeight,
+		Turns:         p.Turns,
+		Rule:          *p.Rule,
+		Boundary:      p.Boundary,
+		PrintProgress: p.PrintProgress,
+		HashLife:      p.HashLife,
+		HashLifeStep:  p.HashLifeStep,
+	},
+		&stubs.None{},
+	)
+	if err != nil {
+		return world, errors.New(fmt.Sprint("Error in distributor calling Init on Broker: ", err.Error()))
+	}
+	return world, nil
+}
+
+//Has the broker load its last checkpoint and returns the world stored in it
+func restoreBroker(broker brokerClient, p Params, c distributorChannels) (util.PackedWorld, error) {
+	restoreResponse := stubs.BrokerRestoreRes{}
+	err := broker.Call(stubs.BrokerRestore, stubs.BrokerRestoreReq{
+		Session:       p.Session,
+		Turns:         p.Turns,
+		PrintProgress: p.PrintProgress,
+		HashLife:      p.HashLife,
+		HashLifeStep:  p.HashLifeStep,
+	}, &restoreResponse)
+	if err != nil {
+		return restoreResponse.World, errors.New(fmt.Sprint("Error in distributor calling Restore on Broker: ", err.Error()))
+	}
+	if restoreResponse.Width != p.ImageWidth || restoreResponse.Height != p.ImageHeight {
+		return restoreResponse.World, errors.New(fmt.Sprintf("Checkpoint is %dx%d but image size is %dx%d",
+			restoreResponse.Width, restoreResponse.Height, p.ImageWidth, p.ImageHeight))
+	}
+
+	for y := 0; y < p.ImageHeight; y++ {
+		for x := 0; x < p.ImageWidth; x++ {
+			if restoreResponse.World.Alive(x, y) {
+				c.events <- CellFlipped{restoreResponse.Turn, util.Cell{X: x, Y: y}}
+			}
+		}
+	}
+	println("Resumed from checkpoint at turn", restoreResponse.Turn, "with rule", restoreResponse.Rule.String(), "on a", restoreResponse.Boundary.String())
+	return restoreResponse.World, nil
+}
+
+//Joins a world already running on the broker, sending its current live cells down cell flipped
+func attachBroker(broker brokerClient, p Params, c distributorChannels, state stubs.BrokerStateRes) (util.PackedWorld, error) {
+	if state.Width != p.ImageWidth || state.Height != p.ImageHeight {
+		return util.PackedWorld{}, errors.New(fmt.Sprintf("Running world is %dx%d but image size is %dx%d",
+			state.Width, state.Height, p.ImageWidth, p.ImageHeight))
+	}
+	println("Attaching to world running on Broker:", state.Details)
+
+	worldResponse := stubs.WorldRes{}
+	err := broker.Call(stubs.BrokerFetch, stubs.SessionReq{Session: p.Session}, &worldResponse)
+	if err != nil {
+		return worldResponse.World, errors.New(fmt.Sprint("Error in distributor calling Fetch on Broker: ", err.Error()))
+	}
+	for y := 0; y < p.ImageHeight; y++ {
+		for x := 0; x < p.ImageWidth; x++ {
+			if worldResponse.World.Alive(x, y) {
+				c.events <- CellFlipped{worldResponse.Turn, util.Cell{X: x, Y: y}}
+			}
+		}
+	}
+	return worldResponse.World, nil
+}
+
+//Fetches the world after a step and sends the cells that differ from shown as CellFlipped followed by TurnComplete,
+//returning the world now shown
+func showStep(broker brokerClient, shown util.PackedWorld, p Params, c distributorChannels) (util.PackedWorld, error) {
+	worldResponse := stubs.WorldRes{}
+	err := broker.Call(stubs.BrokerFetch, stubs.SessionReq{Session: p.Session}, &worldResponse)
+	if err != nil {
+		return shown, errors.New(fmt.Sprint("Error in distributor calling Fetch on Broker: ", err.Error()))
+	}
+	for _, index := range util.Flipped(shown, worldResponse.World) {
+		c.events <- CellFlipped{worldResponse.Turn, util.Cell{X: index % p.ImageWidth, Y: index / p.ImageWidth}}
+	}
+	c.events <- TurnComplete{worldResponse.Turn}
+	return worldResponse.World, nil
+}
+
+//Receives the cells flipped each turn from the broker and sends them as CellFlipped events followed by TurnComplete,
+//world is kept matching what has been sent so keyframes can be turned back into flips
+func streamFlips(broker brokerClient, world util.PackedWorld, p Params, c distributorChannels, stop <-chan struct{}, finished chan<- struct{}) {
+	defer close(finished)
+	for {
+		flipsResponse := stubs.FlipsRes{}
+		err := broker.Call(stubs.BrokerFlips, stubs.SessionReq{Session: p.Session}, &flipsResponse)
+		if err != nil {
+			println("Error in distributor calling Flips on Broker:", err.Error())
+			return
+		}
+
+		for _, diff := range flipsResponse.Diffs {
+			var flipped []util.Cell
+			if diff.Keyframe {
+				flipped = keyframeFlips(world, diff.Cells, p)
+			} else {
+				for _, index := range util.DecodeFlips(diff.Cells) {
+					flipped = append(flipped, util.Cell{X: index % p.ImageWidth, Y: index / p.ImageWidth})
+				}
+			}
+
+			for _, cell := range flipped {
+				world.Flip(cell.X, cell.Y)
+				select {
+				case c.events <- CellFlipped{diff.Turn, cell}:
+				case <-stop:
+					return
+				}
+			}
+			select {
+			case c.events <- TurnComplete{diff.Turn}:
+			case <-stop:
+				return
+			}
+		}
+
+		if flipsResponse.Finished {
+			return
+		}
+		select {
+		case <-stop:
+			return
+		default:
+		}
+	}
+}
+
+//Returns the cells that differ between world and the alive cells listed in a keyframe
+func keyframeFlips(world util.PackedWorld, keyframe []uint32, p Params) []util.Cell {
+	alive := make([][]bool, p.ImageHeight)
+	for y := range alive {
+		alive[y] = make([]bool, p.ImageWidth)
+	}
+	for _, index := range util.DecodeFlips(keyframe) {
+		alive[index/p.ImageWidth][index%p.ImageWidth] = true
+	}
+
+	var flipped []util.Cell
+	for y := 0; y < p.ImageHeight; y++ {
+		for x := 0; x < p.ImageWidth; x++ {
+			if alive[y][x] != world.Alive(x, y) {
+				flipped = append(flipped, util.Cell{X: x, Y: y})
+			}
+		}
+	}
+	return flipped
+}
+
 //Returns list of all alive cells in board
-func calculateAliveCells(world [][]byte, p Params) []util.Cell {
+func calculateAliveCells(world util.PackedWorld, p Params) []util.Cell {
 	cells := make([]util.Cell, 0)
 	for y := 0; y < p.ImageHeight; y++ {
 		for x := 0; x < p.ImageWidth; x++ {
-			if world[y][x] == 255 {
+			if world.Alive(x, y) {
 				cells = append(cells, util.Cell{x, y})
 			}
 		}
@@ -208,14 +512,10 @@ func calculateAliveCells(world [][]byte, p Params) []util.Cell {
 }
 
 //Prepares io for output and sends board down it a pixel at a time
-func sendWorldToPGM(world [][]byte, turn int, p Params, c distributorChannels) {
+func sendWorldToPGM(world util.PackedWorld, turn int, p Params, c distributorChannels) {
 	fileName := fmt.Sprintf("%dx%dx%d", p.ImageHeight, p.ImageWidth, turn)
 	println("Created file", fileName, ".pgm")
 	c.ioCommand <- ioOutput
 	c.ioFilename <- fileName
-	for y := 0; y < p.ImageHeight; y++ {
-		for x := 0; x < p.ImageWidth; x++ {
-			c.ioOutput <- world[y][x]
-		}
-	}
+	sendPackedWorld(c.ioOutput, world)
 }
@@ -19,6 +19,7 @@ type Event interface {
 type AliveCellsCount struct { // implements Event
 	CompletedTurns int
 	CellsCount     int
+	ActiveFraction float64 // fraction of the world near enough to a change to still be calculated, the rest is skipped
 }
 
 // ImageOutputComplete is an Event notifying the user about the completion of output.
@@ -91,7 +92,7 @@ func (event StateChange) GetCompletedTurns() int {
 }
 
 func (event AliveCellsCount) String() string {
-	return fmt.Sprintf("Alive Cells %v", event.CellsCount)
+	return fmt.Sprintf("Alive Cells %v (%.1f%% active)", event.CellsCount, 100*event.ActiveFraction)
 }
 
 func (event AliveCellsCount) GetCompletedTurns() int {
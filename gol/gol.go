@@ -1,18 +1,47 @@
 package gol
 
+import (
+	"uk.ac.bris.cs/gameoflife/broker"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
 // Params provides the details of how to run the Game of Life and which image to load.
 type Params struct {
 	Turns           int
 	Threads         int
+	Rule            *util.Rule    // rule to run the world with, nil means util.Conway. A pointer as the zero util.Rule is B/S, which can be asked for
+	Boundary        util.Boundary // what happens at the edges of the world, torus by default
 	ImageWidth      int
 	ImageHeight     int
 	PrintProgress   bool
 	BrokerAddress   string
 	WorkerAddresses string
+	Session         string           // ID of the session on the Broker to run the world in, empty for the default one
+	Resume          bool             // carry on from the Broker's last checkpoint instead of loading images/WxH.pgm
+	Attach          bool             // join a world already running on the Broker instead of replacing it
+	NoStream        bool             // don't send CellFlipped and TurnComplete every turn, for when nothing is visualising them
+	Tiled           bool             // split the world between workers as a grid of tiles instead of bands of rows
+	HaloDepth       int              // rows of halo workers swap at a time, so they only swap every HaloDepth turns
+	HashLife        bool             // run the world with HashLife instead of on workers, only for a torus with sides that are powers of two
+	HashLifeStep    int              // HashLife jumps up to 2^HashLifeStep turns at a time, fewer to land on Turns
+	InProcess       bool             // run the world in this process instead of on the Broker and Workers, as it is when there's no BrokerAddress
+	InProcessBroker *broker.Settings // settings of the Broker served with InProcess, nil means broker.Defaults. Its Dial is replaced
 }
 
 // Run starts the processing of Game of Life. It should initialise channels and goroutines.
 func Run(p Params, events chan<- Event, keyPresses <-chan rune) {
+	if p.Rule == nil {
+		conway := util.Conway
+		p.Rule = &conway
+	}
+	if p.BrokerAddress == "" {
+		//No Broker to run on, so run the world here
+		p.InProcess = true
+	}
+	if p.InProcessBroker == nil {
+		settings := broker.Defaults
+		p.InProcessBroker = &settings
+	}
 
 	//	TODO: Put the missing channels in here.
 
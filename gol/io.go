@@ -152,3 +152,29 @@ func startIo(p Params, c ioChannels) {
 		}
 	}
 }
+
+// receivePackedWorld reads a world from the io goroutine a cell at a time, packing it one bit per cell
+// as it arrives. alive is called with each alive cell.
+func receivePackedWorld(input <-chan uint8, width, height int, alive func(cell util.Cell)) util.PackedWorld {
+	world := util.NewPackedWorld(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if <-input == 255 {
+				world.Set(x, y, true)
+				alive(util.Cell{X: x, Y: y})
+			}
+		}
+	}
+	return world
+}
+
+// sendPackedWorld unpacks a world and sends it to the io goroutine a cell at a time.
+func sendPackedWorld(output chan<- uint8, world util.PackedWorld) {
+	row := make([]byte, world.Width)
+	for y := 0; y < world.Height; y++ {
+		util.UnpackRow(row, world.Rows[y])
+		for _, cell := range row {
+			output <- cell
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package gol
+
+import (
+	"fmt"
+	"net"
+	"net/rpc"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/broker"
+	"uk.ac.bris.cs/gameoflife/internal/pipenet"
+	"uk.ac.bris.cs/gameoflife/worker"
+)
+
+// brokerClient is what the distributor calls on a Broker, either an *rpc.Client for one across the network
+// or an inProcessBroker served in this process.
+type brokerClient interface {
+	Call(serviceMethod string, args interface{}, reply interface{}) error
+	Go(serviceMethod string, args interface{}, reply interface{}, done chan *rpc.Call) *rpc.Call
+	Close() error
+}
+
+// inProcessBroker is a real Broker and Workers served in this process over pipes rather than the network,
+// so running in process runs the same code as running on a cluster. Closing it hangs up every pipe.
+type inProcessBroker struct {
+	*rpc.Client
+	workerAddresses []string
+	network         *pipenet.Network
+}
+
+// startInProcess : serves a Broker with settings and workers Workers in this process, returning the Broker to call
+func startInProcess(workers int, settings broker.Settings) (*inProcessBroker, error) {
+	network := pipenet.New()
+	settings.Dial = func(address string, timeout time.Duration) (net.Conn, error) {
+		return network.Dial(address)
+	}
+	b := broker.New(settings)
+	err := network.Serve("broker", b)
+	if err != nil {
+		return nil, err
+	}
+
+	var addresses []string
+	for i := 0; i < workers; i++ {
+		//The broker splits the world between the workers, so each only needs the one goroutine
+		w := worker.New(worker.Settings{Swar: true, Threads: 1, Dial: network.Dial})
+		address := fmt.Sprint("worker", i+1)
+		err = network.Serve(address, w)
+		if err != nil {
+			network.Close()
+			return nil, err
+		}
+		addresses = append(addresses, address)
+		//A killed worker stops listening, as a process would by exiting
+		go func() {
+			select {
+			case <-worker.Killed(w):
+				network.Remove(address)
+			case <-network.Closed():
+			}
+		}()
+	}
+
+	conn, err := network.Dial("broker")
+	if err != nil {
+		network.Close()
+		return nil, err
+	}
+	return &inProcessBroker{Client: rpc.NewClient(conn), workerAddresses: addresses, network: network}, nil
+}
+
+// Close : hangs up on the Broker, then every pipe between the Broker and Workers so nothing is left running
+func (b *inProcessBroker) Close() error {
+	err := b.Client.Close()
+	b.network.Close()
+	return err
+}
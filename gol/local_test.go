@@ -0,0 +1,49 @@
+package gol
+
+import (
+	"fmt"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/broker"
+	"uk.ac.bris.cs/gameoflife/internal/testutil"
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// BenchmarkHaloDepth times 100 turns of the 512x512 image on four Workers served in process,
+// in bands and in tiles, with the Workers swapping halos every 1, 2, 4 and 8 turns
+func BenchmarkHaloDepth(b *testing.B) {
+	world := testutil.ReadFixture(b, "512x512x0")
+	expected := testutil.ReadFixture(b, "512x512x100")
+	for _, tiled := range []bool{false, true} {
+		for _, depth := range []int{1, 2, 4, 8} {
+			name := fmt.Sprintf("bands-depth%d", depth)
+			if tiled {
+				name = fmt.Sprintf("tiles-depth%d", depth)
+			}
+			b.Run(name, func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					local, err := startInProcess(4, broker.Defaults)
+					if err != nil {
+						b.Fatal(err)
+					}
+					err = local.Call(stubs.BrokerInit, stubs.BrokerInitReq{World: world, Width: 512, Height: 512, Turns: 100, Rule: util.Conway}, &stubs.None{})
+					if err == nil {
+						err = local.Call(stubs.BrokerStart, stubs.BrokerStartReq{WorkerCount: 4, WorkerAddresses: local.workerAddresses, Tiled: tiled, HaloDepth: depth}, &stubs.None{})
+					}
+					res := stubs.WorldRes{}
+					if err == nil {
+						err = local.Call(stubs.BrokerProgressAll, stubs.SessionReq{}, &res)
+					}
+					_ = local.Close()
+					if err != nil {
+						b.Fatal(err)
+					}
+					if !res.World.Equal(expected) {
+						b.Fatal("world differs from the expected image after 100 turns")
+					}
+				}
+			})
+		}
+	}
+}
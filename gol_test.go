@@ -7,6 +7,7 @@ import (
 	"strings"
 	"testing"
 
+	"uk.ac.bris.cs/gameoflife/broker"
 	"uk.ac.bris.cs/gameoflife/gol"
 	"uk.ac.bris.cs/gameoflife/util"
 )
@@ -46,6 +47,36 @@ func TestGol(t *testing.T) {
 	}
 }
 
+// TestGolAutonomous tests the same images with the Broker served in process letting its workers run turns by themselves.
+func TestGolAutonomous(t *testing.T) {
+	settings := broker.Defaults
+	settings.Autonomous = true
+	for _, size := range []int{16, 64, 512} {
+		for _, turns := range []int{0, 1, 100} {
+			p := gol.Params{ImageWidth: size, ImageHeight: size, Turns: turns, InProcessBroker: &settings}
+			expectedAlive := readAliveCells(
+				"check/images/"+fmt.Sprintf("%vx%vx%v.pgm", p.ImageWidth, p.ImageHeight, turns),
+				p.ImageWidth,
+				p.ImageHeight,
+			)
+			for _, threads := range []int{1, 3, 8, 16} {
+				p.Threads = threads
+				t.Run(fmt.Sprintf("%dx%dx%d-%d", p.ImageWidth, p.ImageHeight, p.Turns, p.Threads), func(t *testing.T) {
+					events := make(chan gol.Event)
+					go gol.Run(p, events, nil)
+					var cells []util.Cell
+					for event := range events {
+						if e, ok := event.(gol.FinalTurnComplete); ok {
+							cells = e.Alive
+						}
+					}
+					assertEqualBoard(t, cells, expectedAlive, p)
+				})
+			}
+		}
+	}
+}
+
 func boardFail(t *testing.T, given, expected []util.Cell, p gol.Params) bool {
 	errorString := fmt.Sprintf("-----------------\n\n  FAILED TEST\n  %vx%v\n  %d Workers\n  %d Turns\n", p.ImageWidth, p.ImageHeight, p.Threads, p.Turns)
 	if p.ImageWidth == 16 && p.ImageHeight == 16 {
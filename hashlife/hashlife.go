@@ -0,0 +1,290 @@
+package hashlife
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// maxNodes is how many nodes are kept before the memoised results are thrown away and the tree is built again,
+// which keeps memory to a few hundred MB.
+const maxNodes = 1 << 21
+
+// node is a square of 2^level by 2^level cells made of four quadrants a level down.
+// Nodes are hash consed, so squares with the same cells are the same node and their futures are only worked out once.
+// The smallest nodes are 8x8 and hold their cells directly.
+type node struct {
+	nw, ne, sw, se *node
+	level          int
+	population     int
+	cells          uint64  // cells of a level 3 node, a byte per row with the leftmost cell in the lowest bit
+	next           []*node // next[j] is the middle half of the node 2^j turns on, once worked out
+}
+
+// Universe is a torus world stored as a quadtree of memoised nodes, able to jump a power of two turns at a time.
+// The torus is tiled to a square so it can be stepped as part of a larger square of copies of itself,
+// which needs the width and height to be powers of two.
+type Universe struct {
+	width  int
+	height int
+	rule   util.Rule
+	root   *node // the world tiled to a square of side 2^root.level
+	turn   int
+
+	leaves map[uint64]*node
+	joins  map[[4]*node]*node
+}
+
+// New builds a universe from a torus world on turn, returning an error if its sides aren't powers of two.
+func New(world util.PackedWorld, rule util.Rule, turn int) (*Universe, error) {
+	if !powerOfTwo(world.Width) || !powerOfTwo(world.Height) {
+		return nil, errors.New(fmt.Sprintf("HashLife needs a world with sides that are powers of two, not %dx%d", world.Width, world.Height))
+	}
+	u := &Universe{width: world.Width, height: world.Height, rule: rule, turn: turn}
+	u.build(world)
+	return u, nil
+}
+
+// Turn returns the turn the universe is on.
+func (u *Universe) Turn() int {
+	return u.turn
+}
+
+// Count returns the number of alive cells in the world.
+func (u *Universe) Count() int {
+	side := 1 << uint(u.root.level)
+	return u.root.population / (side / u.width) / (side / u.height)
+}
+
+// Step moves the world on 2^k turns.
+func (u *Universe) Step(k int) {
+	//Surround the world with copies of itself until the middle half of the square is at least 2^k turns away from its edge,
+	//at least two levels up so the middle half starts on a multiple of the world's side
+	levels := k - u.root.level + 2
+	if levels < 2 {
+		levels = 2
+	}
+	tiled := u.root
+	for i := 0; i < levels; i++ {
+		tiled = u.join(tiled, tiled, tiled, tiled)
+	}
+	result := u.successor(tiled, k)
+	for result.level > u.root.level {
+		result = result.nw
+	}
+	u.root = result
+	u.turn += 1 << uint(k)
+
+	if len(u.joins)+len(u.leaves) > maxNodes {
+		u.build(u.World())
+	}
+}
+
+// StepFor returns the biggest k no more than maxStep with 2^k no more than turns, for jumping to a given turn.
+func StepFor(turns, maxStep int) int {
+	k := 0
+	for k < maxStep && 2<<uint(k) <= turns {
+		k++
+	}
+	return k
+}
+
+// World returns the cells of the world.
+func (u *Universe) World() util.PackedWorld {
+	world := util.NewPackedWorld(u.width, u.height)
+	u.write(world, u.root, 0, 0)
+	return world
+}
+
+// build throws away every node and builds the tree from world, tiling it to a square of at least 8x8
+func (u *Universe) build(world util.PackedWorld) {
+	u.leaves = make(map[uint64]*node)
+	u.joins = make(map[[4]*node]*node)
+	side := 8
+	for side < world.Width || side < world.Height {
+		side *= 2
+	}
+	level := 3
+	for 1<<uint(level) < side {
+		level++
+	}
+	u.root = u.buildNode(world, 0, 0, level)
+}
+
+// buildNode : the node for the square of the tiled world with its top left corner at x, y
+func (u *Universe) buildNode(world util.PackedWorld, x, y, level int) *node {
+	if level == 3 {
+		var cells uint64
+		for row := 0; row < 8; row++ {
+			worldY := (y + row) % world.Height
+			if world.Width >= 8 {
+				//Leaves line up with the bytes of the packed rows
+				cells |= uint64(world.Rows[worldY][x%world.Width/8]) << uint(row*8)
+				continue
+			}
+			for col := 0; col < 8; col++ {
+				if world.Alive((x+col)%world.Width, worldY) {
+					cells |= 1 << uint(row*8+col)
+				}
+			}
+		}
+		return u.leaf(cells)
+	}
+	half := 1 << uint(level-1)
+	return u.join(
+		u.buildNode(world, x, y, level-1),
+		u.buildNode(world, x+half, y, level-1),
+		u.buildNode(world, x, y+half, level-1),
+		u.buildNode(world, x+half, y+half, level-1),
+	)
+}
+
+// write : copies the cells of n, with its top left corner at x, y, into the part of world they cover
+func (u *Universe) write(world util.PackedWorld, n *node, x, y int) {
+	if n.population == 0 || x >= u.width || y >= u.height {
+		return
+	}
+	if n.level == 3 {
+		for row := 0; row < 8 && y+row < u.height; row++ {
+			for col := 0; col < 8 && x+col < u.width; col++ {
+				if n.cells>>uint(row*8+col)&1 == 1 {
+					world.Set(x+col, y+row, true)
+				}
+			}
+		}
+		return
+	}
+	half := 1 << uint(n.level-1)
+	u.write(world, n.nw, x, y)
+	u.write(world, n.ne, x+half, y)
+	u.write(world, n.sw, x, y+half)
+	u.write(world, n.se, x+half, y+half)
+}
+
+// leaf : the 8x8 node with the given cells
+func (u *Universe) leaf(cells uint64) *node {
+	n, ok := u.leaves[cells]
+	if !ok {
+		n = &node{level: 3, cells: cells, population: bits.OnesCount64(cells)}
+		u.leaves[cells] = n
+	}
+	return n
+}
+
+// join : the node made of four quadrants of the same level
+func (u *Universe) join(nw, ne, sw, se *node) *node {
+	key := [4]*node{nw, ne, sw, se}
+	n, ok := u.joins[key]
+	if !ok {
+		n = &node{nw: nw, ne: ne, sw: sw, se: se, level: nw.level + 1,
+			population: nw.population + ne.population + sw.population + se.population}
+		u.joins[key] = n
+	}
+	return n
+}
+
+// centre : the middle half of n, without moving it on any turns
+func (u *Universe) centre(n *node) *node {
+	if n.level == 4 {
+		rows := expand(n)
+		var cells uint64
+		for row := 0; row < 8; row++ {
+			cells |= uint64(rows[row+4]>>4&0xff) << uint(row*8)
+		}
+		return u.leaf(cells)
+	}
+	return u.join(n.nw.se, n.ne.sw, n.sw.ne, n.se.nw)
+}
+
+// successor : the middle half of n moved on 2^j turns, j being at most n.level-2.
+// Works out nine overlapping nodes a level down, then combines them into four that are moved on again,
+// each half of the turns when j is as big as it can be, otherwise the nine are just centred and the four take all of them
+func (u *Universe) successor(n *node, j int) *node {
+	if n.next == nil {
+		n.next = make([]*node, n.level-1)
+	} else if n.next[j] != nil {
+		return n.next[j]
+	}
+
+	var result *node
+	if n.population == 0 && !u.rule.Birth[0] {
+		result = n.nw
+	} else if n.level == 4 {
+		result = u.simulate(n, 1<<uint(j))
+	} else {
+		n00, n01, n02 := n.nw, u.join(n.nw.ne, n.ne.nw, n.nw.se, n.ne.sw), n.ne
+		n10 := u.join(n.nw.sw, n.nw.se, n.sw.nw, n.sw.ne)
+		n11 := u.join(n.nw.se, n.ne.sw, n.sw.ne, n.se.nw)
+		n12 := u.join(n.ne.sw, n.ne.se, n.se.nw, n.se.ne)
+		n20, n21, n22 := n.sw, u.join(n.sw.ne, n.se.nw, n.sw.se, n.se.sw), n.se
+
+		nine := []*node{n00, n01, n02, n10, n11, n12, n20, n21, n22}
+		fullSpeed := j == n.level-2
+		rest := j
+		if fullSpeed {
+			rest = j - 1
+		}
+		for i, sub := range nine {
+			if fullSpeed {
+				nine[i] = u.successor(sub, rest)
+			} else {
+				nine[i] = u.centre(sub)
+			}
+		}
+		result = u.join(
+			u.successor(u.join(nine[0], nine[1], nine[3], nine[4]), rest),
+			u.successor(u.join(nine[1], nine[2], nine[4], nine[5]), rest),
+			u.successor(u.join(nine[3], nine[4], nine[6], nine[7]), rest),
+			u.successor(u.join(nine[4], nine[5], nine[7], nine[8]), rest),
+		)
+	}
+	n.next[j] = result
+	return result
+}
+
+// simulate : the middle 8x8 of a 16x16 node moved on up to 4 turns, counting each cell's neighbours one by one
+func (u *Universe) simulate(n *node, turns int) *node {
+	rows := expand(n)
+	for t := 0; t < turns; t++ {
+		var next [16]uint16
+		for y := 1; y < 15; y++ {
+			for x := 1; x < 15; x++ {
+				count := 0
+				for dy := -1; dy <= 1; dy++ {
+					for dx := -1; dx <= 1; dx++ {
+						if (dx != 0 || dy != 0) && rows[y+dy]>>uint(x+dx)&1 == 1 {
+							count++
+						}
+					}
+				}
+				alive := rows[y]>>uint(x)&1 == 1
+				if (alive && u.rule.Survive[count]) || (!alive && u.rule.Birth[count]) {
+					next[y] |= 1 << uint(x)
+				}
+			}
+		}
+		rows = next
+	}
+	var cells uint64
+	for row := 0; row < 8; row++ {
+		cells |= uint64(rows[row+4]>>4&0xff) << uint(row*8)
+	}
+	return u.leaf(cells)
+}
+
+// expand : the cells of a 16x16 node as a row per uint16, the leftmost cell in the lowest bit
+func expand(n *node) [16]uint16 {
+	var rows [16]uint16
+	for row := 0; row < 8; row++ {
+		shift := uint(row * 8)
+		rows[row] = uint16(n.nw.cells>>shift&0xff) | uint16(n.ne.cells>>shift&0xff)<<8
+		rows[row+8] = uint16(n.sw.cells>>shift&0xff) | uint16(n.se.cells>>shift&0xff)<<8
+	}
+	return rows
+}
+
+func powerOfTwo(n int) bool {
+	return n > 0 && n&(n-1) == 0
+}
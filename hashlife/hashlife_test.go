@@ -0,0 +1,79 @@
+package hashlife
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/internal/testutil"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+func randomWorld(width, height int) util.PackedWorld {
+	world := util.NewPackedWorld(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			world.Set(x, y, rand.Intn(3) == 0)
+		}
+	}
+	return world
+}
+
+// TestFixtures checks jumping to turn 100 in steps of 64, 32 and 4 turns matches the expected images in check/images.
+func TestFixtures(t *testing.T) {
+	for _, size := range []int{16, 64, 512} {
+		u, err := New(testutil.ReadFixture(t, fmt.Sprintf("%dx%dx0", size, size)), util.Conway, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for u.Turn() < 100 {
+			u.Step(StepFor(100-u.Turn(), 6))
+		}
+		expected := testutil.ReadFixture(t, fmt.Sprintf("%dx%dx100", size, size))
+		if !u.World().Equal(expected) {
+			t.Errorf("%dx%d differs from the expected image after 100 turns", size, size)
+		}
+		if u.Count() != expected.Count() {
+			t.Errorf("%dx%d counted %d alive cells, expected %d", size, size, u.Count(), expected.Count())
+		}
+	}
+}
+
+// TestStep checks jumps of different sizes on worlds that aren't square, or are smaller than a leaf, or have other rules,
+// against working out each turn one at a time.
+func TestStep(t *testing.T) {
+	highLife, _ := util.ParseRule("B36/S23")
+	for _, test := range []struct {
+		width, height int
+		rule          util.Rule
+	}{
+		{64, 64, util.Conway},
+		{64, 32, util.Conway},
+		{16, 128, highLife},
+		{4, 2, util.Conway},
+	} {
+		world := randomWorld(test.width, test.height)
+		u, err := New(world, test.rule, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range []int{0, 1, 3, 5, 2} {
+			u.Step(k)
+			for i := 0; i < 1<<uint(k); i++ {
+				world = testutil.NextTorus(world, test.rule)
+			}
+			if !u.World().Equal(world) {
+				t.Errorf("%dx%d %v differs after a step of %d turns, on turn %d", test.width, test.height, test.rule, 1<<uint(k), u.Turn())
+			}
+		}
+	}
+}
+
+// TestNewSizes checks worlds with sides that aren't powers of two are turned down.
+func TestNewSizes(t *testing.T) {
+	for _, size := range [][2]int{{48, 64}, {64, 100}, {3, 4}} {
+		if _, err := New(util.NewPackedWorld(size[0], size[1]), util.Conway, 0); err == nil {
+			t.Errorf("expected an error for a %dx%d world", size[0], size[1])
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Package pipenet stands in for the network between a controller, Broker and Workers served in one process,
+// dialling an address serves a new net.Pipe with whatever is served at it.
+package pipenet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"sync"
+)
+
+// Network is the addresses served in process and every pipe dialled to them.
+type Network struct {
+	servers map[string]*rpc.Server
+	conns   map[string][]net.Conn //both ends of the pipes dialled to each address
+	closed  chan struct{}
+	mu      sync.Mutex
+}
+
+// New makes a Network with nothing served on it.
+func New() *Network {
+	return &Network{servers: make(map[string]*rpc.Server), conns: make(map[string][]net.Conn), closed: make(chan struct{})}
+}
+
+// Serve registers receiver with its own rpc.Server, served to anything dialling address.
+func (n *Network) Serve(address string, receiver interface{}) error {
+	server := rpc.NewServer()
+	err := server.Register(receiver)
+	if err != nil {
+		return errors.New(fmt.Sprint("Error serving ", address, " in process: ", err.Error()))
+	}
+	n.mu.Lock()
+	n.servers[address] = server
+	n.mu.Unlock()
+	return nil
+}
+
+// Dial returns a new pipe to whatever is served at address, the far end served on its own goroutine.
+func (n *Network) Dial(address string) (net.Conn, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	server, ok := n.servers[address]
+	if !ok {
+		return nil, errors.New(fmt.Sprint("nothing served in process at ", address))
+	}
+	client, conn := net.Pipe()
+	n.conns[address] = append(n.conns[address], client, conn)
+	go server.ServeConn(conn)
+	return client, nil
+}
+
+// Remove stops serving address, so dialling it fails. Pipes already dialled to it stay up.
+func (n *Network) Remove(address string) {
+	n.mu.Lock()
+	delete(n.servers, address)
+	n.mu.Unlock()
+}
+
+// Hangup stops serving address and closes every pipe dialled to it,
+// as when the process listening there is killed.
+func (n *Network) Hangup(address string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.servers, address)
+	for _, conn := range n.conns[address] {
+		_ = conn.Close()
+	}
+	delete(n.conns, address)
+}
+
+// Closed is closed once Close has been called.
+func (n *Network) Closed() <-chan struct{} {
+	return n.closed
+}
+
+// Close stops serving anything and closes every pipe dialled.
+func (n *Network) Close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	select {
+	case <-n.closed:
+		return
+	default:
+	}
+	close(n.closed)
+	n.servers = make(map[string]*rpc.Server)
+	for _, conns := range n.conns {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}
+	n.conns = make(map[string][]net.Conn)
+}
@@ -0,0 +1,54 @@
+// Package testutil holds what the tests of more than one package need,
+// the images in check/images and a reference to check turns against.
+package testutil
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// ReadFixture loads one of the PGM images in check/images as a packed world.
+func ReadFixture(t testing.TB, name string) util.PackedWorld {
+	//Found from this file, so it works from the tests of any package
+	_, file, _, _ := runtime.Caller(0)
+	data, err := ioutil.ReadFile(filepath.Join(filepath.Dir(file), "..", "..", "check", "images", name+".pgm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Fields(string(data))
+	width, _ := strconv.Atoi(fields[1])
+	height, _ := strconv.Atoi(fields[2])
+	image := []byte(fields[4])
+	world := make([][]byte, height)
+	for y := range world {
+		world[y] = image[y*width : (y+1)*width]
+	}
+	return util.PackWorld(world, width, height)
+}
+
+// NextTorus works out the next turn of a world wrapped round a torus, counting each cell's neighbours one by one.
+func NextTorus(world util.PackedWorld, rule util.Rule) util.PackedWorld {
+	next := util.NewPackedWorld(world.Width, world.Height)
+	for y := 0; y < world.Height; y++ {
+		for x := 0; x < world.Width; x++ {
+			count := 0
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					if (dx != 0 || dy != 0) && world.Alive((x+dx+world.Width)%world.Width, (y+dy+world.Height)%world.Height) {
+						count++
+					}
+				}
+			}
+			if (world.Alive(x, y) && rule.Survive[count]) || (!world.Alive(x, y) && rule.Birth[count]) {
+				next.Set(x, y, true)
+			}
+		}
+	}
+	return next
+}
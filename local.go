@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// localCluster is a Broker and Workers started by the controller as subprocesses, for running with -local.
+type localCluster struct {
+	dir       string //where the binaries and their logs are kept
+	processes []*exec.Cmd
+	exited    []chan struct{} //closed once each process has exited
+	stopped   chan struct{}   //closed once the cluster is being stopped, so processes exiting aren't reported as crashes
+	stopOnce  sync.Once
+}
+
+// startLocalCluster builds the Broker and Worker, then starts a Broker and workers Workers on free ports,
+// returning the cluster along with the address of the Broker and of each Worker.
+// Anything already started is stopped again if it returns an error.
+func startLocalCluster(workers int) (cluster *localCluster, brokerAddress string, workerAddresses []string, err error) {
+	dir, err := ioutil.TempDir("", "gol-local")
+	if err != nil {
+		return nil, "", nil, errors.New(fmt.Sprint("Error in controller making a directory for the local cluster: ", err.Error()))
+	}
+	cluster = &localCluster{dir: dir, stopped: make(chan struct{})}
+	defer func() {
+		if err != nil {
+			cluster.stop()
+		}
+	}()
+
+	for _, name := range []string{"Broker", "Worker"} {
+		build := exec.Command("go", "build", "-o", filepath.Join(dir, name), filepath.Join("GOLWorker", name+".go"))
+		output, err := build.CombinedOutput()
+		if err != nil {
+			return cluster, "", nil, errors.New(fmt.Sprint("Error in controller building ", name, ": ", err.Error(), "\n", string(output)))
+		}
+	}
+
+	brokerAddress, err = freeAddress()
+	if err != nil {
+		return cluster, "", nil, err
+	}
+	err = cluster.start("Broker", "broker.log", "-address", brokerAddress)
+	if err != nil {
+		return cluster, "", nil, err
+	}
+	for i := 0; i < workers; i++ {
+		address, err := freeAddress()
+		if err != nil {
+			return cluster, "", nil, err
+		}
+		err = cluster.start("Worker", fmt.Sprintf("worker%d.log", i+1), "-address", address)
+		if err != nil {
+			return cluster, "", nil, err
+		}
+		workerAddresses = append(workerAddresses, address)
+	}
+
+	//Wait for everything to be listening before the controller connects
+	for i, address := range append([]string{brokerAddress}, workerAddresses...) {
+		err = waitForListener(address, cluster.exited[i], 10*time.Second)
+		if err != nil {
+			return cluster, "", nil, err
+		}
+	}
+	fmt.Println("Started a Broker and", workers, "Workers, logs in", dir)
+	return cluster, brokerAddress, workerAddresses, nil
+}
+
+// start : runs one of the binaries built into the cluster's directory, writing what it prints to logName
+func (c *localCluster) start(name, logName string, args ...string) error {
+	logFile, err := os.Create(filepath.Join(c.dir, logName))
+	if err != nil {
+		return errors.New(fmt.Sprint("Error in controller making log for ", name, ": ", err.Error()))
+	}
+	cmd := exec.Command(filepath.Join(c.dir, name), args...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	stopWithParent(cmd)
+	err = cmd.Start()
+	if err != nil {
+		_ = logFile.Close()
+		return errors.New(fmt.Sprint("Error in controller starting ", name, ": ", err.Error()))
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		err := cmd.Wait()
+		_ = logFile.Close()
+		select {
+		case <-c.stopped:
+		default:
+			//Nobody asked it to stop, so let whoever is watching know it crashed. Exiting cleanly means it was killed with k
+			if err != nil {
+				println(name, "started with", args[len(args)-1], "exited:", fmt.Sprint(err), "see", logFile.Name())
+			}
+		}
+		close(exited)
+	}()
+	c.processes = append(c.processes, cmd)
+	c.exited = append(c.exited, exited)
+	return nil
+}
+
+// stop : kills every process in the cluster and waits for them to exit, then removes the binaries, keeping the logs
+func (c *localCluster) stop() {
+	c.stopOnce.Do(func() {
+		close(c.stopped)
+	})
+	for i, cmd := range c.processes {
+		_ = cmd.Process.Kill()
+		<-c.exited[i]
+	}
+	_ = os.Remove(filepath.Join(c.dir, "Broker"))
+	_ = os.Remove(filepath.Join(c.dir, "Worker"))
+}
+
+// freeAddress : an address on localhost with a port nothing is listening on, picked by the OS
+func freeAddress() (string, error) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return "", errors.New(fmt.Sprint("Error in controller finding a free port: ", err.Error()))
+	}
+	address := listener.Addr().String()
+	err = listener.Close()
+	if err != nil {
+		return "", errors.New(fmt.Sprint("Error in controller finding a free port: ", err.Error()))
+	}
+	return address, nil
+}
+
+// waitForListener : waits until something accepts connections on address, giving up if the process exits or after timeout
+func waitForListener(address string, exited <-chan struct{}, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		conn, err := net.Dial("tcp", address)
+		if err == nil {
+			return conn.Close()
+		}
+		select {
+		case <-exited:
+			return errors.New(fmt.Sprint("process to listen on ", address, " exited before it started listening"))
+		case <-time.After(50 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			return errors.New(fmt.Sprint("nothing listening on ", address, " after ", timeout))
+		}
+	}
+}
@@ -0,0 +1,12 @@
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// stopWithParent : has Linux kill the process if the controller dies without stopping it, such as on a crash.
+// Linux does this when the thread that started it exits, which main keeps locked to its own thread
+func stopWithParent(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGKILL}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "os/exec"
+
+// stopWithParent : other systems can't kill the process when the controller dies, so a crash can leave it running
+func stopWithParent(cmd *exec.Cmd) {}
@@ -3,9 +3,15 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
+	"os/signal"
 	"runtime"
+	"strings"
+	"syscall"
+	"uk.ac.bris.cs/gameoflife/broker"
 	"uk.ac.bris.cs/gameoflife/gol"
 	"uk.ac.bris.cs/gameoflife/sdl"
+	"uk.ac.bris.cs/gameoflife/util"
 )
 
 // main is the function called when starting Game of Life with 'go run .'
@@ -17,7 +23,7 @@ func main() {
 		&params.Threads,
 		"t",
 		8,
-		"Specify the number of worker threads to use. Defaults to 8.")
+		"Specify the number of bands of rows to split the world into. Only this many Workers are used, and with fewer each calculates several bands at once. Defaults to 8.")
 
 	flag.IntVar(
 		&params.ImageWidth,
@@ -43,8 +49,13 @@ func main() {
 		"The address of Broker. Defaults to localhost:8032")
 	workerAddresses := flag.String(
 		"workerAddresses",
-		"localhost:8030,localhost:8031",
-		"The addresses of Workers seperated by a comma, will throw if less than threads. Defaults to localhost:8030")
+		"",
+		"The addresses of Workers seperated by a comma. Defaults to the Workers registered with the Broker. Only the first t of them are used")
+
+	session := flag.String(
+		"session",
+		"",
+		"ID of the session on the Broker to run the world in, so several controllers can each run their own world at once on different Workers. Defaults to the default session.")
 
 	noVis := flag.Bool(
 		"noVis",
@@ -56,14 +67,138 @@ func main() {
 		false,
 		"Workers and Broker print out each turn of world for debugging purposes (only works for low turns and short worlds)")
 
+	rule := flag.String(
+		"rule",
+		"B3/S23",
+		"The Life-like rule to run in B/S notation, e.g. B36/S23 for HighLife. Defaults to B3/S23.")
+
+	boundary := flag.String(
+		"boundary",
+		"torus",
+		"What happens at the edges of the world: torus, dead, mirror or klein. Defaults to torus.")
+
+	resume := flag.Bool(
+		"resume",
+		false,
+		"Carry on from the Broker's last checkpoint instead of loading the image in ./images")
+
+	attach := flag.Bool(
+		"attach",
+		true,
+		"Join a world left running on the Broker by a controller that quit, instead of starting a new one. Defaults to true.")
+
+	tiles := flag.Bool(
+		"tiles",
+		false,
+		"Split the world between Workers as a grid of tiles rather than bands of rows, so less is swapped between them each turn.")
+
+	haloDepth := flag.Int(
+		"haloDepth",
+		1,
+		"Rows of halo Workers swap at a time, so they only need to swap every this many turns. Defaults to 1.")
+
+	hashLife := flag.Bool(
+		"hashlife",
+		false,
+		"Run the world with HashLife on the Broker instead of on Workers, for very long runs. Only works on a torus with sides that are powers of two.")
+
+	hashLifeStep := flag.Int(
+		"hashlifeStep",
+		10,
+		"HashLife jumps up to 2 to the power of this many turns at a time, so the visualisation only sees every so many turns. Defaults to 10.")
+
+	inProcess := flag.Bool(
+		"inProcess",
+		false,
+		"Run the world in this process instead of on the Broker and Workers, for small boards and trying things out.")
+
+	autonomous := flag.Bool(
+		"autonomous",
+		false,
+		"With -inProcess, let the Workers run turns by themselves between snapshots, as a Broker started with -autonomous does.")
+
+	local := flag.Int(
+		"local",
+		0,
+		"Start a Broker and this many Workers on free ports on this machine, stopping them again on exit, instead of using a Broker already running.")
+
 	flag.Parse()
 
+	parsedRule, err := util.ParseRule(*rule)
+	if err != nil {
+		fmt.Println("Invalid rule:", err)
+		os.Exit(1)
+	}
+	params.Rule = &parsedRule
+	params.Boundary, err = util.ParseBoundary(*boundary)
+	if err != nil {
+		fmt.Println("Invalid boundary:", err)
+		os.Exit(1)
+	}
+	if *hashLifeStep < 0 || *hashLifeStep > 60 {
+		fmt.Println("Invalid hashlifeStep:", *hashLifeStep, "should be between 0 and 60")
+		os.Exit(1)
+	}
+	if params.Threads < 1 {
+		fmt.Println("Invalid t:", params.Threads, "should be at least 1")
+		os.Exit(1)
+	}
+	if *local < 0 || (*local > 0 && *inProcess) {
+		fmt.Println("Invalid local:", *local, "should be a number of Workers, and can't be used with -inProcess")
+		os.Exit(1)
+	}
+	params.Resume = *resume
+	params.Attach = *attach
+	params.NoStream = *noVis
+	params.Tiled = *tiles
+	params.HaloDepth = *haloDepth
+	params.HashLife = *hashLife
+	params.HashLifeStep = *hashLifeStep
+	params.InProcess = *inProcess
+	if *autonomous {
+		if !*inProcess {
+			fmt.Println("Invalid autonomous: only for -inProcess, otherwise start the Broker with -autonomous")
+			os.Exit(1)
+		}
+		settings := broker.Defaults
+		settings.Autonomous = true
+		params.InProcessBroker = &settings
+	}
 	params.PrintProgress = *printProgress
 	params.BrokerAddress = *brokerAddress
 	params.WorkerAddresses = *workerAddresses
+	params.Session = *session
+	if *local > 0 {
+		cluster, localBroker, localWorkers, err := startLocalCluster(*local)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer cluster.stop()
+		//Stop the cluster on Ctrl-C as well, exiting skips the defer
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			cluster.stop()
+			os.Exit(1)
+		}()
+		params.BrokerAddress = localBroker
+		params.WorkerAddresses = strings.Join(localWorkers, ",")
+		//Use every Worker started unless -t asks for some other number of bands
+		threadsGiven := false
+		flag.Visit(func(f *flag.Flag) {
+			threadsGiven = threadsGiven || f.Name == "t"
+		})
+		if !threadsGiven {
+			params.Threads = *local
+		}
+	}
 	fmt.Println("Threads:", params.Threads)
 	fmt.Println("Width:", params.ImageWidth)
 	fmt.Println("Height:", params.ImageHeight)
+	fmt.Println("Rule:", *params.Rule)
+	fmt.Println("Boundary:", params.Boundary)
 
 	keyPresses := make(chan rune, 10)
 	events := make(chan gol.Event, 1000)
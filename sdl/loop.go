@@ -26,6 +26,21 @@ sdlLoop:
 					keyPresses <- 'k'
 				case sdl.K_d:
 					keyPresses <- 'd'
+				case sdl.K_EQUALS, sdl.K_PLUS, sdl.K_KP_PLUS:
+					keyPresses <- '+'
+				case sdl.K_MINUS, sdl.K_KP_MINUS:
+					keyPresses <- '-'
+				}
+				//Step counts are typed a digit at a time, so only take each key once as it goes down
+				if e.Type == sdl.KEYDOWN {
+					switch {
+					case e.Keysym.Sym >= sdl.K_0 && e.Keysym.Sym <= sdl.K_9:
+						keyPresses <- rune(e.Keysym.Sym)
+					case e.Keysym.Sym == sdl.K_n:
+						keyPresses <- 'n'
+					case e.Keysym.Sym == sdl.K_u:
+						keyPresses <- 'u'
+					}
 				}
 			}
 		}
@@ -1,74 +1,224 @@
 package stubs
 
+import (
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
 var WorkerInit = "Worker.Init"
 var WorkerStart = "Worker.Start"
 var WorkerProgress = "Worker.Progress"
+var WorkerRunUntil = "Worker.RunUntil"
+var WorkerReport = "Worker.Report"
 var WorkerHalo = "Worker.Halo"
+var WorkerSideHalo = "Worker.SideHalo"
+var WorkerRebalance = "Worker.Rebalance"
+var WorkerMigrate = "Worker.Migrate"
 var WorkerCount = "Worker.Count"
 var WorkerFetch = "Worker.Fetch"
 var WorkerKill = "Worker.Kill"
+var WorkerPing = "Worker.Ping"
 
 var BrokerQueryState = "Broker.QueryState"
 var BrokerInit = "Broker.Init"
+var BrokerRestore = "Broker.Restore"
 var BrokerStart = "Broker.Start"
 var BrokerProgressAll = "Broker.ProgressAll"
 var BrokerCount = "Broker.Count"
 var BrokerPause = "Broker.Pause"
+var BrokerResume = "Broker.Resume"
+var BrokerStep = "Broker.Step"
 var BrokerFetch = "Broker.Fetch"
+var BrokerSubscribe = "Broker.Subscribe"
+var BrokerUnsubscribe = "Broker.Unsubscribe"
+var BrokerFlips = "Broker.Flips"
 var BrokerQuit = "Broker.Quit"
 var BrokerKill = "Broker.Kill"
+var BrokerRegister = "Broker.Register"
+var BrokerHeartbeat = "Broker.Heartbeat"
+var BrokerLeave = "Broker.Leave"
+var BrokerAddWorker = "Broker.AddWorker"
+var BrokerRemoveWorker = "Broker.RemoveWorker"
 
 type None struct {
 	//Empty
 }
 
+type SessionReq struct {
+	Session string //world on the Broker the call is for, empty for the default one
+}
+
 type BrokerStateRes struct {
 	StillCalculating bool
+	Paused           bool
 	Details          string
+	Turn             int
+	Width            int
+	Height           int
 }
 
 type BrokerInitReq struct {
-	World         [][]byte
+	Session       string
+	World         util.PackedWorld
 	Width         int
 	Height        int
 	Turns         int
+	Rule          util.Rule
+	Boundary      util.Boundary
+	PrintProgress bool
+	HashLife      bool //run the world on the Broker with HashLife instead of on Workers
+	HashLifeStep  int  //HashLife jumps up to 2^HashLifeStep turns at a time
+}
+
+type BrokerRestoreReq struct {
+	Session       string
+	Turns         int
 	PrintProgress bool
+	HashLife      bool
+	HashLifeStep  int
+}
+
+type BrokerRestoreRes struct {
+	World    util.PackedWorld
+	Width    int
+	Height   int
+	Turn     int
+	Rule     util.Rule
+	Boundary util.Boundary
 }
 
 type BrokerStartReq struct {
-	WorkerCount     int
-	WorkerAddresses []string
+	Session         string
+	WorkerCount     int      //bands of rows to split the world into, 0 for one per Worker given or a fair share of those registered
+	WorkerAddresses []string //empty to use the Workers registered with the Broker, up to WorkerCount of them
+	Tiled           bool     //split the world into a grid of tiles rather than bands of rows
+	HaloDepth       int      //rows and columns of halo workers swap at a time, 0 or 1 to swap every turn
+}
+
+type RegisterReq struct {
+	Address  string //address the worker listens on
+	Capacity int    //how much of the world the worker can take compared to others
+}
+
+type HeartbeatRes struct {
+	Registered bool //false if the Broker has forgotten the worker, so it needs to register again
+}
+
+type ScaleReq struct {
+	Session string
+	Address string //worker to add or remove, empty for the Broker to choose
+}
+
+type ScaleRes struct {
+	Address     string //worker that was added or removed
+	WorkerCount int    //workers running the world now
 }
 
 type WorkerInitReq struct {
-	World         [][]byte
+	World         util.PackedWorld
 	Width         int
 	Height        int
+	Turn          int
+	Epoch         int
+	Rule          util.Rule
+	Boundary      util.Boundary
+	HaloDepth     int //rows and columns of halo swapped at a time, the worker calculates this many turns between swaps
+	Threads       int //bands of rows to calculate the section in at once, 0 to use the worker's own -threads
 	PrintProgress bool
 }
 
 type WorkerStartReq struct {
-	AboveAdr string
+	AboveAdr   string
+	LeftAdr    string //empty when the worker holds whole rows of the world
+	TopEdge    bool   //worker holds the top row of the world
+	BottomEdge bool   //worker holds the bottom row of the world
+	LeftEdge   bool   //worker holds the left column of the world
+	RightEdge  bool   //worker holds the right column of the world
+}
+
+type WorkerProgressReq struct {
+	Flips bool //whether to send back the cells that flipped this turn
+}
+
+type WorkerRunReq struct {
+	Turn  int  //turn to stop at
+	Flips bool //record the cells flipped each turn for Report
+}
+
+type WorkerReportRes struct {
+	Turn  int           //turn the worker is on
+	Turns []Turn        //turns since the last Report with the cells they flipped, only when recording flips
+	Busy  time.Duration //time spent calculating since the last Report
+}
+
+type WorkerRebalanceReq struct {
+	Top        int //rows the top of the section moves down by, giving them to the worker above, or up by if negative
+	Bottom     int //rows the bottom of the section moves down by, taking them from the worker below, or up by if negative
+	AboveAdr   string
+	TopEdge    bool
+	BottomEdge bool
+	Join       bool //worker has just been added, so has no turn being calculated and no rows of its own yet
+	Leave      bool //worker is being removed, so gives away all its rows and stops
 }
 
 type Turn struct {
-	Turn int
+	Turn    int
+	Busy    time.Duration //time spent calculating the turn
+	Flipped []uint32      //row-major indices of flipped cells in the worker's section, encoded with util.EncodeFlips
 }
 
 type WorkerHaloReqRes struct {
-	Halo []byte
+	Halo  util.PackedWorld
+	Same  bool //Halo is the same as the last one sent, so was left out
+	Epoch int
 }
 
 type WorldRes struct {
-	World [][]byte
+	World util.PackedWorld
 	Turn  int
 }
 
 type CountCellRes struct {
-	Count int
-	Turn  int
+	Count  int
+	Active int //cells near enough to a change to be calculated this turn, the rest are skipped
+	Turn   int
+}
+
+// TurnDiff is the cells that flipped on one turn, as row-major indices encoded with util.EncodeFlips.
+// A Keyframe lists every alive cell instead, and is sent when the controller fell too far behind to follow the flips.
+type TurnDiff struct {
+	Turn     int
+	Keyframe bool
+	Cells    []uint32
+}
+
+type SubscribeReq struct {
+	Session string
+	Resync  bool //send a Keyframe first, for a controller that doesn't know the current world
+}
+
+type FlipsRes struct {
+	Diffs    []TurnDiff
+	Finished bool //no more diffs will be sent for this world
+}
+
+type StepReq struct {
+	Session string
+	Turns   int //turns to run the paused world on by
+	Until   int //turn to run the paused world up to instead, when set
+}
+
+type KillRes struct {
+	World        util.PackedWorld //world on the turn it stopped, for the controller to save
+	Turn         int
+	Exited       []string //Workers that confirmed they were exiting and stopped listening
+	Unresponsive []string //Workers that didn't within the Broker's worker timeout
+	Unreachable  []string //Workers that couldn't be connected to, so may already have gone or never have been there
+	Output       string
 }
 
 type PauseRes struct {
+	Turn   int //turn the world paused or carried on from
 	Output string
 }
@@ -0,0 +1,123 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Boundary is what happens to cells on the edge of the world.
+type Boundary int
+
+const (
+	// Torus wraps the world round left to right and top to bottom.
+	Torus Boundary = iota
+	// DeadBorder treats every cell outside the world as dead.
+	DeadBorder
+	// Mirror reflects the world at its edges, so cells outside copy the edge cell next to them.
+	Mirror
+	// KleinBottle wraps left to right like a torus, but the world is flipped left to right when wrapping top to bottom.
+	KleinBottle
+)
+
+var boundaryNames = map[Boundary]string{
+	Torus:       "torus",
+	DeadBorder:  "dead",
+	Mirror:      "mirror",
+	KleinBottle: "klein",
+}
+
+// ParseBoundary reads a boundary from its name: torus, dead, mirror or klein.
+func ParseBoundary(name string) (Boundary, error) {
+	for boundary, boundaryName := range boundaryNames {
+		if name == boundaryName {
+			return boundary, nil
+		}
+	}
+	return Torus, errors.New(fmt.Sprint("boundary ", name, " should be one of torus, dead, mirror or klein"))
+}
+
+func (boundary Boundary) String() string {
+	if name, ok := boundaryNames[boundary]; ok {
+		return name
+	}
+	return "Incorrect Boundary"
+}
+
+// WrapsHorizontally is whether the left column of the world neighbours the right column.
+func (boundary Boundary) WrapsHorizontally() bool {
+	return boundary == Torus || boundary == KleinBottle
+}
+
+// WrapsVertically is whether the top row of the world neighbours the bottom row.
+func (boundary Boundary) WrapsVertically() bool {
+	return boundary == Torus || boundary == KleinBottle
+}
+
+// FillSideHalo fills the depth columns of halo on the left or right of a padded section of the world.
+// If the section holds whole rows of a world that wraps they come from its far side, otherwise from the boundary.
+func FillSideHalo(padded PackedWorld, depth int, boundary Boundary, wraps, left bool) {
+	width := padded.Width - 2*depth
+	for y := 0; y < padded.Height; y++ {
+		for j := 0; j < depth; j++ {
+			if left {
+				if wraps {
+					padded.Set(j, y, padded.Alive(width+j, y))
+				} else if boundary == Mirror {
+					padded.Set(depth-1-j, y, padded.Alive(depth+j, y))
+				} else {
+					padded.Set(j, y, false)
+				}
+			} else {
+				if wraps {
+					padded.Set(depth+width+j, y, padded.Alive(depth+j, y))
+				} else if boundary == Mirror {
+					padded.Set(depth+width+j, y, padded.Alive(depth+width-1-j, y))
+				} else {
+					padded.Set(depth+width+j, y, false)
+				}
+			}
+		}
+	}
+}
+
+// FillEdgeHalo fills the depth rows of halo above or below a padded section at the top or bottom of a world that doesn't wrap.
+// The side halos should be filled first, so the corners come out right.
+func FillEdgeHalo(padded PackedWorld, depth int, boundary Boundary, top bool) {
+	height := padded.Height - 2*depth
+	for j := 0; j < depth; j++ {
+		row, mirrored := padded.Rows[depth+height+j], padded.Rows[depth+height-1-j]
+		if top {
+			row, mirrored = padded.Rows[depth-1-j], padded.Rows[depth+j]
+		}
+		if boundary == Mirror {
+			copy(row, mirrored)
+			continue
+		}
+		for x := range row {
+			row[x] = 0
+		}
+	}
+}
+
+// FillHalo fills the depth cells of halo round a whole world padded by them with the cells the boundary puts beyond each edge.
+func FillHalo(padded PackedWorld, depth int, boundary Boundary) {
+	width, height := padded.Width-2*depth, padded.Height-2*depth
+	if boundary.WrapsVertically() {
+		for j := 0; j < depth; j++ {
+			top, bottom := padded.Block(depth, height+j, width, 1), padded.Block(depth, depth+j, width, 1)
+			if boundary == KleinBottle {
+				//Wrapping top to bottom flips the world left to right
+				top.Mirror()
+				bottom.Mirror()
+			}
+			padded.Paste(depth, j, top)
+			padded.Paste(depth, depth+height+j, bottom)
+		}
+	}
+	FillSideHalo(padded, depth, boundary, boundary.WrapsHorizontally(), true)
+	FillSideHalo(padded, depth, boundary, boundary.WrapsHorizontally(), false)
+	if !boundary.WrapsVertically() {
+		FillEdgeHalo(padded, depth, boundary, true)
+		FillEdgeHalo(padded, depth, boundary, false)
+	}
+}
@@ -0,0 +1,85 @@
+package util
+
+import "testing"
+
+// aliveBeyond is whether the cell at x, y is alive, following the boundary for cells outside the world.
+func aliveBeyond(world PackedWorld, x, y int, boundary Boundary) bool {
+	width, height := world.Width, world.Height
+	if y < 0 || y >= height {
+		switch boundary {
+		case DeadBorder:
+			return false
+		case Mirror:
+			if y < 0 {
+				y = -1 - y
+			} else {
+				y = 2*height - 1 - y
+			}
+		default:
+			y = (y + height) % height
+			if boundary == KleinBottle {
+				x = width - 1 - x
+			}
+		}
+	}
+	if x < 0 || x >= width {
+		switch boundary {
+		case DeadBorder:
+			return false
+		case Mirror:
+			if x < 0 {
+				x = -1 - x
+			} else {
+				x = 2*width - 1 - x
+			}
+		default:
+			x = (x + width) % width
+		}
+	}
+	return world.Alive(x, y)
+}
+
+// TestFillHalo checks every boundary's halo, and the turn worked out from it,
+// against looking up each cell and counting its neighbours one at a time.
+func TestFillHalo(t *testing.T) {
+	highLife, _ := ParseRule("B36/S23")
+	for _, boundary := range []Boundary{Torus, DeadBorder, Mirror, KleinBottle} {
+		for _, width := range []int{3, 63, 64, 65, 130} {
+			for _, depth := range []int{1, 2} {
+				world := PackWorld(randomWorld(width, 7), width, 7)
+				padded := NewPackedWorld(width+2*depth, 7+2*depth)
+				padded.Paste(depth, depth, world)
+				FillHalo(padded, depth, boundary)
+				for y := -depth; y < 7+depth; y++ {
+					for x := -depth; x < width+depth; x++ {
+						if padded.Alive(x+depth, y+depth) != aliveBeyond(world, x, y, boundary) {
+							t.Errorf("%v width %d depth %d halo cell %d,%d is wrong", boundary, width, depth, x, y)
+						}
+					}
+				}
+
+				next := NewPackedWorld(padded.Width, padded.Height)
+				NextStateSWAR(padded, next, highLife)
+				for y := 0; y < 7; y++ {
+					for x := 0; x < width; x++ {
+						count := 0
+						for dy := -1; dy <= 1; dy++ {
+							for dx := -1; dx <= 1; dx++ {
+								if (dx != 0 || dy != 0) && aliveBeyond(world, x+dx, y+dy, boundary) {
+									count++
+								}
+							}
+						}
+						expected := highLife.Birth[count]
+						if world.Alive(x, y) {
+							expected = highLife.Survive[count]
+						}
+						if next.Alive(x+depth, y+depth) != expected {
+							t.Errorf("%v width %d depth %d cell %d,%d alive is %v, expected %v", boundary, width, depth, x, y, !expected, expected)
+						}
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package util_test
+
+import (
+	"fmt"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/internal/testutil"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// nextSWAR works out the next turn of a world wrapped round a torus, by filling a halo of one cell round it
+// and running NextStateSWAR on that.
+func nextSWAR(world util.PackedWorld, rule util.Rule) util.PackedWorld {
+	padded := util.NewPackedWorld(world.Width+2, world.Height+2)
+	padded.Paste(1, 1, world)
+	util.FillHalo(padded, 1, util.Torus)
+	next := util.NewPackedWorld(world.Width+2, world.Height+2)
+	util.NextStateSWAR(padded, next, rule)
+	return next.Block(1, 1, world.Width, world.Height)
+}
+
+// TestNextStateSWAR checks the kernel against the expected images in check/images.
+func TestNextStateSWAR(t *testing.T) {
+	for _, size := range []int{16, 64, 512} {
+		world := testutil.ReadFixture(t, fmt.Sprintf("%dx%dx0", size, size))
+		for turn := 1; turn <= 100; turn++ {
+			world = nextSWAR(world, util.Conway)
+			if turn != 1 && turn != 100 {
+				continue
+			}
+			expected := testutil.ReadFixture(t, fmt.Sprintf("%dx%dx%d", size, size, turn))
+			if !world.Equal(expected) {
+				t.Errorf("%dx%d differs from the expected image after %d turns", size, size, turn)
+			}
+		}
+	}
+}
+
+// TestNextStateSWARReference checks a few turns of a rule other than Conway's against counting each cell's neighbours.
+func TestNextStateSWARReference(t *testing.T) {
+	highLife, _ := util.ParseRule("B36/S23")
+	world := testutil.ReadFixture(t, "64x64x0")
+	for turn := 1; turn <= 20; turn++ {
+		expected := testutil.NextTorus(world, highLife)
+		world = nextSWAR(world, highLife)
+		if !world.Equal(expected) {
+			t.Fatalf("turn %d differs from counting each cell's neighbours", turn)
+		}
+	}
+}
+
+// BenchmarkNextStateSWAR and BenchmarkNextStateRows time a turn of the 512x512 image with each kernel,
+// into a world from the turn before as a worker does
+func BenchmarkNextStateSWAR(b *testing.B) {
+	world := testutil.ReadFixture(b, "512x512x0")
+	next := util.NewPackedWorld(world.Width, world.Height)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		util.NextStateSWAR(world, next, util.Conway)
+	}
+}
+
+func BenchmarkNextStateRows(b *testing.B) {
+	world := testutil.ReadFixture(b, "512x512x0")
+	next := util.NewPackedWorld(world.Width, world.Height)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		//Only live cells are set, so it needs a dead world each turn as a worker makes one
+		next = util.NewPackedWorld(world.Width, world.Height)
+		util.NextStateRows(world, next, util.Conway, 0, world.Height, nil)
+	}
+}
@@ -0,0 +1,40 @@
+package util
+
+import "math/bits"
+
+// EncodeFlips turns sorted row-major cell indices into the gaps between them,
+// which gob sends as small varints rather than full indices.
+func EncodeFlips(indices []int) []uint32 {
+	gaps := make([]uint32, len(indices))
+	previous := 0
+	for i, index := range indices {
+		gaps[i] = uint32(index - previous)
+		previous = index
+	}
+	return gaps
+}
+
+// DecodeFlips turns gaps produced by EncodeFlips back into row-major cell indices.
+func DecodeFlips(gaps []uint32) []int {
+	indices := make([]int, len(gaps))
+	index := 0
+	for i, gap := range gaps {
+		index += int(gap)
+		indices[i] = index
+	}
+	return indices
+}
+
+// Flipped returns the row-major indices of every cell that differs between two worlds of the same size.
+func Flipped(old, new PackedWorld) []int {
+	var flipped []int
+	for y := range old.Rows {
+		//Compare 8 cells at a time, only looking at the cells of bytes that differ
+		for i := range old.Rows[y] {
+			for diff := old.Rows[y][i] ^ new.Rows[y][i]; diff != 0; diff &= diff - 1 {
+				flipped = append(flipped, y*old.Width+i*8+bits.TrailingZeros8(diff))
+			}
+		}
+	}
+	return flipped
+}
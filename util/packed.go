@@ -0,0 +1,169 @@
+package util
+
+import (
+	"bytes"
+	"math/bits"
+)
+
+// PackedWorld is a world stored one bit per cell rather than one byte, a set bit being an alive cell.
+// Each row is packed into whole 64 bit words of 8 bytes each, so kernels can work on a word at a time,
+// with the leftmost cell in the lowest bit of the first byte. Bits past the end of a row are always left clear.
+type PackedWorld struct {
+	Width  int
+	Height int
+	Rows   [][]byte
+}
+
+// NewPackedWorld returns an all dead world of the given size.
+func NewPackedWorld(width, height int) PackedWorld {
+	world := PackedWorld{Width: width, Height: height, Rows: make([][]byte, height)}
+	for y := range world.Rows {
+		world.Rows[y] = make([]byte, (width+63)/64*8)
+	}
+	return world
+}
+
+// PackWorld packs a world of bytes, where alive cells are 255, one bit per cell.
+func PackWorld(world [][]byte, width, height int) PackedWorld {
+	packed := NewPackedWorld(width, height)
+	for y := 0; y < height; y++ {
+		PackRow(packed.Rows[y], world[y][:width])
+	}
+	return packed
+}
+
+// Unpack turns the world back into bytes, with alive cells 255 and dead cells 0.
+func (w PackedWorld) Unpack() [][]byte {
+	world := make([][]byte, w.Height)
+	for y := range world {
+		world[y] = make([]byte, w.Width)
+		UnpackRow(world[y], w.Rows[y])
+	}
+	return world
+}
+
+// PackRow packs a row of bytes, where alive cells are 255, into packed.
+func PackRow(packed, row []byte) {
+	for i := range packed {
+		packed[i] = 0
+	}
+	for x, cell := range row {
+		if cell == 255 {
+			packed[x>>3] |= 1 << uint(x&7)
+		}
+	}
+}
+
+// UnpackRow fills row with the cells of a packed row, alive cells being 255.
+func UnpackRow(row, packed []byte) {
+	for x := range row {
+		row[x] = -(packed[x>>3] >> uint(x&7) & 1)
+	}
+}
+
+// Alive reports whether the cell at x, y is alive.
+func (w PackedWorld) Alive(x, y int) bool {
+	return w.Rows[y][x>>3]>>uint(x&7)&1 == 1
+}
+
+// Set makes the cell at x, y alive or dead.
+func (w PackedWorld) Set(x, y int, alive bool) {
+	if alive {
+		w.Rows[y][x>>3] |= 1 << uint(x&7)
+	} else {
+		w.Rows[y][x>>3] &^= 1 << uint(x&7)
+	}
+}
+
+// Flip makes the cell at x, y alive if it was dead and dead if it was alive.
+func (w PackedWorld) Flip(x, y int) {
+	w.Rows[y][x>>3] ^= 1 << uint(x&7)
+}
+
+// Count returns the number of alive cells.
+func (w PackedWorld) Count() int {
+	count := 0
+	for _, row := range w.Rows {
+		for _, cells := range row {
+			count += bits.OnesCount8(cells)
+		}
+	}
+	return count
+}
+
+// Equal reports whether two worlds are the same size with the same cells alive.
+func (w PackedWorld) Equal(other PackedWorld) bool {
+	if w.Width != other.Width || w.Height != other.Height {
+		return false
+	}
+	for y := range w.Rows {
+		if !bytes.Equal(w.Rows[y], other.Rows[y]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Block copies out the width by height block of cells with its top left corner at x, y.
+func (w PackedWorld) Block(x, y, width, height int) PackedWorld {
+	block := NewPackedWorld(width, height)
+	for row := 0; row < height; row++ {
+		copyBits(block.Rows[row], 0, w.Rows[y+row], x, width)
+	}
+	return block
+}
+
+// Paste copies every cell of block into the world with its top left corner at x, y.
+func (w PackedWorld) Paste(x, y int, block PackedWorld) {
+	for row := 0; row < block.Height; row++ {
+		copyBits(w.Rows[y+row], x, block.Rows[row], 0, block.Width)
+	}
+}
+
+// Mirror flips the world left to right, in place.
+func (w PackedWorld) Mirror() {
+	for _, row := range w.Rows {
+		for left, right := 0, w.Width-1; left < right; left, right = left+1, right-1 {
+			leftBit, rightBit := row[left>>3]>>uint(left&7)&1, row[right>>3]>>uint(right&7)&1
+			if leftBit != rightBit {
+				row[left>>3] ^= 1 << uint(left&7)
+				row[right>>3] ^= 1 << uint(right&7)
+			}
+		}
+	}
+}
+
+// copyBits copies n bits from src starting at bit srcOff to dst starting at bit dstOff, up to a byte at a time.
+func copyBits(dst []byte, dstOff int, src []byte, srcOff int, n int) {
+	for n > 0 {
+		take := 8
+		if n < take {
+			take = n
+		}
+		writeBits(dst, dstOff, readBits(src, srcOff, take), take)
+		dstOff += take
+		srcOff += take
+		n -= take
+	}
+}
+
+// readBits returns the n bits, at most 8, of src starting at bit off.
+func readBits(src []byte, off, n int) byte {
+	i, shift := off>>3, uint(off&7)
+	cells := uint16(src[i]) >> shift
+	if shift+uint(n) > 8 {
+		cells |= uint16(src[i+1]) << (8 - shift)
+	}
+	return byte(cells & (1<<uint(n) - 1))
+}
+
+// writeBits sets the n bits, at most 8, of dst starting at bit off to cells.
+func writeBits(dst []byte, off int, cells byte, n int) {
+	i, shift := off>>3, uint(off&7)
+	mask := uint16(1<<uint(n)-1) << shift
+	shifted := uint16(cells) << shift
+	dst[i] = dst[i]&^byte(mask) | byte(shifted)
+	if shift+uint(n) > 8 {
+		dst[i+1] = dst[i+1]&^byte(mask>>8) | byte(shifted>>8)
+	}
+}
@@ -0,0 +1,116 @@
+package util
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// randomWorld returns a world of bytes with about a third of its cells alive.
+func randomWorld(width, height int) [][]byte {
+	world := make([][]byte, height)
+	for y := range world {
+		world[y] = make([]byte, width)
+		for x := range world[y] {
+			if rand.Intn(3) == 0 {
+				world[y][x] = 255
+			}
+		}
+	}
+	return world
+}
+
+// TestPackWorld checks worlds of awkward widths survive being packed and unpacked.
+func TestPackWorld(t *testing.T) {
+	for _, width := range []int{1, 7, 8, 9, 16, 63, 100} {
+		world := randomWorld(width, 5)
+		packed := PackWorld(world, width, 5)
+		if unpacked := packed.Unpack(); !reflect.DeepEqual(unpacked, world) {
+			t.Errorf("width %d unpacked as %v, expected %v", width, unpacked, world)
+		}
+
+		count := 0
+		for y := range world {
+			for x := range world[y] {
+				if packed.Alive(x, y) != (world[y][x] == 255) {
+					t.Errorf("width %d cell %d,%d alive is %v", width, x, y, packed.Alive(x, y))
+				}
+				if world[y][x] == 255 {
+					count++
+				}
+			}
+		}
+		if packed.Count() != count {
+			t.Errorf("width %d counted %d alive, expected %d", width, packed.Count(), count)
+		}
+	}
+}
+
+// TestBlockPaste checks blocks copied out of and pasted into a world at any bit offset move the right cells.
+func TestBlockPaste(t *testing.T) {
+	world := randomWorld(37, 9)
+	packed := PackWorld(world, 37, 9)
+	for _, b := range [][4]int{{0, 0, 37, 9}, {1, 2, 30, 5}, {5, 0, 3, 9}, {13, 4, 24, 1}, {36, 8, 1, 1}} {
+		x, y, width, height := b[0], b[1], b[2], b[3]
+		block := packed.Block(x, y, width, height)
+		for by := 0; by < height; by++ {
+			for bx := 0; bx < width; bx++ {
+				if block.Alive(bx, by) != (world[y+by][x+bx] == 255) {
+					t.Errorf("block %v cell %d,%d alive is %v", b, bx, by, block.Alive(bx, by))
+				}
+			}
+		}
+		if block.Count() != countBytes(block.Unpack()) {
+			t.Errorf("block %v has bits set past the end of its rows", b)
+		}
+
+		pasted := NewPackedWorld(37, 9)
+		pasted.Paste(x, y, block)
+		if !reflect.DeepEqual(pasted.Block(x, y, width, height), block) {
+			t.Errorf("block %v pasted back as %v", b, pasted.Block(x, y, width, height))
+		}
+		if pasted.Count() != block.Count() {
+			t.Errorf("block %v pasting changed cells outside it", b)
+		}
+	}
+}
+
+// TestMirror checks mirroring reverses each row.
+func TestMirror(t *testing.T) {
+	world := randomWorld(21, 4)
+	packed := PackWorld(world, 21, 4)
+	packed.Mirror()
+	for y := range world {
+		for x := range world[y] {
+			if packed.Alive(20-x, y) != (world[y][x] == 255) {
+				t.Errorf("cell %d,%d wasn't mirrored", x, y)
+			}
+		}
+	}
+}
+
+// TestFlipped checks the cells found to differ between two worlds are the ones that were flipped, in order.
+func TestFlipped(t *testing.T) {
+	old := PackWorld(randomWorld(21, 4), 21, 4)
+	new := PackWorld(old.Unpack(), 21, 4)
+	expected := []int{0, 7, 8, 20, 21, 50, 83}
+	for _, index := range expected {
+		new.Flip(index%21, index/21)
+	}
+	if flipped := Flipped(old, new); !reflect.DeepEqual(flipped, expected) {
+		t.Errorf("flipped %v, expected %v", flipped, expected)
+	}
+}
+
+// countBytes counts the alive cells in a world of bytes.
+func countBytes(world [][]byte) int {
+	count := 0
+	for _, row := range world {
+		for _, cell := range row {
+			if cell == 255 {
+				count++
+			}
+		}
+	}
+	return count
+}
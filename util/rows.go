@@ -0,0 +1,76 @@
+package util
+
+// NextStateRows works out rows startY up to endY of the next turn of world into next a cell at a time,
+// the slower counterpart of NextStateSWARRows. next must start with every cell dead, as only cells alive next turn are set.
+// The outermost ring of cells is left dead, and tiles not marked in active are copied across as they are.
+func NextStateRows(world, next PackedWorld, rule Rule, startY, endY int, active []bool) {
+	if startY < 1 {
+		startY = 1
+	}
+	if endY > world.Height-1 {
+		endY = world.Height - 1
+	}
+	if startY >= endY {
+		return
+	}
+	width := world.Width
+
+	//Unpack a row at a time, along with the rows either side of it, to count neighbours
+	rows := [][]byte{make([]byte, width), make([]byte, width), make([]byte, width)}
+	UnpackRow(rows[1], world.Rows[startY-1])
+	UnpackRow(rows[2], world.Rows[startY])
+	for y := startY; y < endY; y++ {
+		rows[0], rows[1], rows[2] = rows[1], rows[2], rows[0]
+		UnpackRow(rows[2], world.Rows[y+1])
+		tileRow := y / TileSize * (len(world.Rows[y]) / 8)
+		for x := 1; x < width-1; x++ {
+			if tile := x / TileSize; active != nil && !active[tileRow+tile] {
+				//Nothing near this tile changed last turn, so it stays the same
+				copy(next.Rows[y][tile*8:tile*8+8], world.Rows[y][tile*8:tile*8+8])
+				x = (tile+1)*TileSize - 1
+				continue
+			}
+			count := liveNeighbourCount(1, x, rows)
+			if rows[1][x] == 255 { //if cells alive:-
+				if rule.Survive[count] { //live cells with a surviving neighbour count are unaffected
+					next.Set(x, y, true)
+				}
+				//any other live cell dies
+			} else { //cells dead
+				if rule.Birth[count] { //dead cells with a birth neighbour count become alive
+					next.Set(x, y, true)
+				}
+			}
+		}
+	}
+}
+
+// liveNeighbourCount counts the live cells round x in the middle of three unpacked rows.
+func liveNeighbourCount(y, x int, world [][]byte) int8 {
+	var count int8 = 0
+	if world[y+1][x+1] == 255 {
+		count++
+	}
+	if world[y+1][x] == 255 {
+		count++
+	}
+	if world[y+1][x-1] == 255 {
+		count++
+	}
+	if world[y][x+1] == 255 {
+		count++
+	}
+	if world[y][x-1] == 255 {
+		count++
+	}
+	if world[y-1][x+1] == 255 {
+		count++
+	}
+	if world[y-1][x] == 255 {
+		count++
+	}
+	if world[y-1][x-1] == 255 {
+		count++
+	}
+	return count
+}
@@ -0,0 +1,66 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Rule is a Life-like rule. A dead cell with n live neighbours is born if Birth[n],
+// and a live cell with n live neighbours survives if Survive[n].
+type Rule struct {
+	Birth   [9]bool
+	Survive [9]bool
+}
+
+// Conway is the standard Game of Life rule, B3/S23.
+var Conway = Rule{
+	Birth:   [9]bool{3: true},
+	Survive: [9]bool{2: true, 3: true},
+}
+
+// ParseRule reads a rule in B/S notation, such as "B36/S23" for HighLife or "B2/S" for Seeds.
+func ParseRule(notation string) (Rule, error) {
+	rule := Rule{}
+	parts := strings.Split(strings.ToUpper(strings.TrimSpace(notation)), "/")
+	if len(parts) != 2 {
+		return rule, errors.New(fmt.Sprint("rule ", notation, " should look like B3/S23"))
+	}
+
+	seenBirth, seenSurvive := false, false
+	for _, part := range parts {
+		var counts *[9]bool
+		switch {
+		case strings.HasPrefix(part, "B") && !seenBirth:
+			counts, seenBirth = &rule.Birth, true
+		case strings.HasPrefix(part, "S") && !seenSurvive:
+			counts, seenSurvive = &rule.Survive, true
+		default:
+			return rule, errors.New(fmt.Sprint("rule ", notation, " should have one B part and one S part"))
+		}
+		for _, digit := range part[1:] {
+			if digit < '0' || digit > '8' {
+				return rule, errors.New(fmt.Sprint("rule ", notation, " has a neighbour count that isn't 0-8"))
+			}
+			counts[digit-'0'] = true
+		}
+	}
+	return rule, nil
+}
+
+// String gives the rule in B/S notation.
+func (rule Rule) String() string {
+	notation := "B"
+	for n, born := range rule.Birth {
+		if born {
+			notation += fmt.Sprint(n)
+		}
+	}
+	notation += "/S"
+	for n, survives := range rule.Survive {
+		if survives {
+			notation += fmt.Sprint(n)
+		}
+	}
+	return notation
+}
@@ -0,0 +1,34 @@
+package util
+
+import "testing"
+
+// TestParseRule checks some well known rules parse and print back in B/S notation.
+func TestParseRule(t *testing.T) {
+	tests := map[string]string{
+		"B3/S23":         "B3/S23",
+		"b36/s23":        "B36/S23",
+		"S34678/B3678":   "B3678/S34678",
+		"B2/S":           "B2/S",
+		" B012345678/S ": "B012345678/S",
+	}
+	for notation, expected := range tests {
+		rule, err := ParseRule(notation)
+		if err != nil {
+			t.Errorf("%q: %v", notation, err)
+			continue
+		}
+		if rule.String() != expected {
+			t.Errorf("%q parsed as %v, expected %v", notation, rule, expected)
+		}
+	}
+
+	if rule, _ := ParseRule("B3/S23"); rule != Conway {
+		t.Errorf("B3/S23 parsed as %v, expected Conway", rule)
+	}
+
+	for _, notation := range []string{"", "B3", "B3/S9", "B3/B3", "23/3", "B3/S23/X"} {
+		if _, err := ParseRule(notation); err == nil {
+			t.Errorf("%q should not parse", notation)
+		}
+	}
+}
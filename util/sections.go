@@ -0,0 +1,109 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// CountBands returns how many bands of rows the world is split into when wanted are asked for but only workers are connected,
+// each worker then calculating several at once. It is 0 if no more than the workers were asked for,
+// and an error if there would be more bands than rows.
+func CountBands(wanted, workers, height int) (int, error) {
+	if wanted <= workers {
+		return 0, nil
+	}
+	if wanted > height {
+		return 0, errors.New(fmt.Sprintf("can't split %d rows into %d bands, ask for at most %d", height, wanted, height))
+	}
+	return wanted, nil
+}
+
+// ShareBands shares bands out between workers as evenly as possible, the first workers taking any left over.
+func ShareBands(bands, workers int) []int {
+	shares := make([]int, workers)
+	for i := range shares {
+		shares[i] = bands / workers
+		if i < bands%workers {
+			shares[i]++
+		}
+	}
+	return shares
+}
+
+// ChooseGrid picks the rows and columns of tiles for the workers that need the fewest cells swapped each turn.
+func ChooseGrid(workerCount, width, height int, boundary Boundary) (int, int) {
+	bestRows, bestCols := workerCount, 1
+	bestHalo := -1
+	for cols := 1; cols <= workerCount; cols++ {
+		if workerCount%cols != 0 {
+			continue
+		}
+		rows := workerCount / cols
+		if cols > width || rows > height {
+			continue
+		}
+		//an even number of columns of tiles can't mirror each other across an odd width
+		if boundary == KleinBottle && cols%2 == 0 && width%2 == 1 {
+			continue
+		}
+		halo := cols*height + rows*width
+		if bestHalo == -1 || halo < bestHalo {
+			bestRows, bestCols, bestHalo = rows, cols, halo
+		}
+	}
+	return bestRows, bestCols
+}
+
+// SplitSections splits length into parts as evenly as possible, returning where each part starts with length on the end.
+// Symmetric parts are the same size as the part the same distance from the other end,
+// which an odd length can't have across an even number of parts, ChooseGrid never asking for that.
+func SplitSections(length, parts int, symmetric bool) []int {
+	sectionLength := length / parts
+	remainingLength := length % parts
+	sections := make([]int, parts+1)
+	sections[0] = 0
+	for i := 1; i < parts+1; i++ {
+		sections[i] = sections[i-1] + sectionLength
+		if symmetric {
+			//hand the remainder out in pairs from the outside in, with any odd one left over going in the middle
+			part := i - 1
+			if part > parts-1-part {
+				part = parts - 1 - part
+			}
+			if part < remainingLength/2 || (remainingLength%2 == 1 && i-1 == parts/2) {
+				sections[i]++
+			}
+		} else if i <= remainingLength {
+			sections[i]++
+		}
+	}
+	return sections
+}
+
+// SplitWeighted splits length into a part for each weight, sized by the weights if they are all given,
+// returning where each part starts with length on the end like SplitSections.
+func SplitWeighted(length int, weights []int) []int {
+	total := 0
+	for _, weight := range weights {
+		if weight <= 0 {
+			return SplitSections(length, len(weights), false)
+		}
+		total += weight
+	}
+	sections := make([]int, len(weights)+1)
+	share := 0
+	for i := 1; i < len(weights); i++ {
+		share += weights[i-1]
+		sections[i] = int(math.Round(float64(length) * float64(share) / float64(total)))
+		//every part needs at least one row, and enough left over for the parts after it
+		if sections[i] <= sections[i-1] {
+			sections[i] = sections[i-1] + 1
+		}
+		if sections[i] > length-(len(weights)-i) {
+			sections[i] = length - (len(weights) - i)
+		}
+	}
+	sections[len(weights)] = length
+	return sections
+}
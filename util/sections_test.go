@@ -0,0 +1,124 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestCountBands checks bands are only asked for past the workers, and never more than the rows.
+func TestCountBands(t *testing.T) {
+	tests := []struct {
+		wanted, workers, height int
+		bands                   int
+		fails                   bool
+	}{
+		{0, 4, 16, 0, false},
+		{4, 4, 16, 0, false},
+		{3, 4, 16, 0, false},
+		{8, 4, 16, 8, false},
+		{16, 4, 16, 16, false},
+		{17, 4, 16, 0, true},
+	}
+	for _, test := range tests {
+		bands, err := CountBands(test.wanted, test.workers, test.height)
+		if bands != test.bands || (err != nil) != test.fails {
+			t.Errorf("CountBands(%d, %d, %d) = %d, %v, expected %d, failing %v", test.wanted, test.workers, test.height, bands, err, test.bands, test.fails)
+		}
+	}
+}
+
+func TestShareBands(t *testing.T) {
+	tests := []struct {
+		bands, workers int
+		shares         []int
+	}{
+		{0, 3, []int{0, 0, 0}},
+		{3, 3, []int{1, 1, 1}},
+		{7, 3, []int{3, 2, 2}},
+		{8, 3, []int{3, 3, 2}},
+		{16, 1, []int{16}},
+	}
+	for _, test := range tests {
+		if shares := ShareBands(test.bands, test.workers); !reflect.DeepEqual(shares, test.shares) {
+			t.Errorf("ShareBands(%d, %d) = %v, expected %v", test.bands, test.workers, shares, test.shares)
+		}
+	}
+}
+
+// TestChooseGrid checks the grid keeps the halo smallest, fits in the world and can mirror round a klein bottle.
+func TestChooseGrid(t *testing.T) {
+	tests := []struct {
+		workers, width, height int
+		boundary               Boundary
+		rows, cols             int
+	}{
+		{1, 64, 64, Torus, 1, 1},
+		{4, 64, 64, Torus, 2, 2},
+		{6, 64, 64, Torus, 3, 2},
+		{8, 512, 16, Torus, 1, 8},
+		{8, 16, 512, Torus, 8, 1},
+		{7, 64, 64, Torus, 7, 1},
+		{4, 1, 64, Torus, 4, 1},
+		{4, 65, 64, KleinBottle, 4, 1},
+		{4, 64, 64, KleinBottle, 2, 2},
+	}
+	for _, test := range tests {
+		if rows, cols := ChooseGrid(test.workers, test.width, test.height, test.boundary); rows != test.rows || cols != test.cols {
+			t.Errorf("ChooseGrid(%d, %d, %d, %v) = %dx%d, expected %dx%d", test.workers, test.width, test.height, test.boundary, rows, cols, test.rows, test.cols)
+		}
+	}
+}
+
+func TestSplitSections(t *testing.T) {
+	tests := []struct {
+		length, parts int
+		symmetric     bool
+		sections      []int
+	}{
+		{16, 1, false, []int{0, 16}},
+		{16, 4, false, []int{0, 4, 8, 12, 16}},
+		{18, 4, false, []int{0, 5, 10, 14, 18}},
+		{18, 4, true, []int{0, 5, 9, 13, 18}},
+		{17, 3, true, []int{0, 6, 11, 17}},
+		{16, 3, true, []int{0, 5, 11, 16}},
+		{3, 3, true, []int{0, 1, 2, 3}},
+	}
+	//an odd length across an even number of parts can't be symmetric, but still has to be split up
+	if sections := SplitSections(19, 4, true); sections[4] != 19 {
+		t.Errorf("SplitSections(19, 4, true) = %v, expected it to end at 19", sections)
+	}
+	for _, test := range tests {
+		sections := SplitSections(test.length, test.parts, test.symmetric)
+		if !reflect.DeepEqual(sections, test.sections) {
+			t.Errorf("SplitSections(%d, %d, %v) = %v, expected %v", test.length, test.parts, test.symmetric, sections, test.sections)
+		}
+		if !test.symmetric {
+			continue
+		}
+		for i := 0; i < test.parts; i++ {
+			if sections[i+1]-sections[i] != sections[test.parts-i]-sections[test.parts-1-i] {
+				t.Errorf("SplitSections(%d, %d, true) = %v, part %d isn't the size of its mirror", test.length, test.parts, sections, i)
+			}
+		}
+	}
+}
+
+func TestSplitWeighted(t *testing.T) {
+	tests := []struct {
+		length   int
+		weights  []int
+		sections []int
+	}{
+		{16, []int{1, 1}, []int{0, 8, 16}},
+		{16, []int{3, 1}, []int{0, 12, 16}},
+		{16, []int{1, 2, 1}, []int{0, 4, 12, 16}},
+		{16, []int{0, 5}, []int{0, 8, 16}},
+		{3, []int{100, 1, 1}, []int{0, 1, 2, 3}},
+		{3, []int{1, 1, 100}, []int{0, 1, 2, 3}},
+	}
+	for _, test := range tests {
+		if sections := SplitWeighted(test.length, test.weights); !reflect.DeepEqual(sections, test.sections) {
+			t.Errorf("SplitWeighted(%d, %v) = %v, expected %v", test.length, test.weights, sections, test.sections)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package util
+
+import "encoding/binary"
+
+// NextStateSWAR works out the next turn of world into next, which must be the same size, 64 cells at a time.
+// Each word of cells has its neighbours added up with full adders working on all 64 cells at once,
+// rather than counting them a cell at a time. Every cell but the outermost ring is calculated,
+// the ring is left dead as it is missing neighbours.
+func NextStateSWAR(world, next PackedWorld, rule Rule) {
+	NextStateSWARRows(world, next, rule, 0, world.Height, nil, nil)
+}
+
+// NextStateSWARRows works out rows startY up to endY of the next turn of world into next, as NextStateSWAR does.
+// Calls working on different rows of the same worlds can run at the same time.
+// Tiles not marked in active, from ActiveTiles, are copied across as they are rather than calculated, nil active calculates every tile.
+// Tiles with a cell that changed are marked in changed, as ChangedTiles would, unless it is nil.
+func NextStateSWARRows(world, next PackedWorld, rule Rule, startY, endY int, active, changed []bool) {
+	if world.Height < 3 || world.Width < 3 {
+		for y := startY; y < endY; y++ {
+			killRow(world.Rows[y], next.Rows[y], y/TileSize*(len(world.Rows[y])/8), changed)
+		}
+		return
+	}
+	words := len(world.Rows[0]) / 8
+	edges := edgeMasks(world.Width, words)
+	births, survivals := ruleCounts(rule)
+
+	if startY == 0 {
+		killRow(world.Rows[0], next.Rows[0], 0, changed)
+		startY = 1
+	}
+	if endY == world.Height {
+		killRow(world.Rows[world.Height-1], next.Rows[world.Height-1], (world.Height-1)/TileSize*words, changed)
+		endY = world.Height - 1
+	}
+	for y := startY; y < endY; y++ {
+		above, row, below := world.Rows[y-1], world.Rows[y], world.Rows[y+1]
+		tileRow := y / TileSize * words
+		for i := 0; i < words; i++ {
+			if active != nil && !active[tileRow+i] {
+				copy(next.Rows[y][i*8:i*8+8], row[i*8:i*8+8])
+				continue
+			}
+			//Neighbours to the west of each cell are the row shifted up a bit, carrying in the top bit of the word before
+			aW, a, aE := shiftedWords(above, i, words)
+			rW, r, rE := shiftedWords(row, i, words)
+			bW, b, bE := shiftedWords(below, i, words)
+
+			//Add up the eight neighbours of every cell into a 4 bit count, one bit of the count per word
+			s0, c0 := fullAdder(aW, a, aE)
+			s1, c1 := fullAdder(rW, rE, bW)
+			s2, c2 := b^bE, b&bE
+			ones, c3 := fullAdder(s0, s1, s2)
+			t, c4 := fullAdder(c0, c1, c2)
+			twos, c5 := t^c3, t&c3
+			fours, eights := c4^c5, c4&c5
+
+			cells := uint64(0)
+			for _, count := range births {
+				cells |= countIs(count, ones, twos, fours, eights) &^ r
+			}
+			for _, count := range survivals {
+				cells |= countIs(count, ones, twos, fours, eights) & r
+			}
+			cells &= edges[i]
+			if changed != nil && cells != r {
+				changed[tileRow+i] = true
+			}
+			binary.LittleEndian.PutUint64(next.Rows[y][i*8:], cells)
+		}
+	}
+}
+
+// shiftedWords : the i-th word of a packed row, along with it shifted so each cell lines up with its west and east neighbour
+func shiftedWords(row []byte, i, words int) (west, word, east uint64) {
+	word = binary.LittleEndian.Uint64(row[i*8:])
+	west = word << 1
+	if i > 0 {
+		west |= binary.LittleEndian.Uint64(row[(i-1)*8:]) >> 63
+	}
+	east = word >> 1
+	if i < words-1 {
+		east |= binary.LittleEndian.Uint64(row[(i+1)*8:]) << 63
+	}
+	return
+}
+
+// fullAdder : adds three words a bit at a time, giving the sum and carry for all 64 bits at once
+func fullAdder(a, b, c uint64) (sum, carry uint64) {
+	sum = a ^ b ^ c
+	carry = a&b | c&(a^b)
+	return
+}
+
+// countIs : the cells whose neighbour count, held a bit per word, equals count
+func countIs(count int, ones, twos, fours, eights uint64) uint64 {
+	if count&1 == 0 {
+		ones = ^ones
+	}
+	if count&2 == 0 {
+		twos = ^twos
+	}
+	if count&4 == 0 {
+		fours = ^fours
+	}
+	if count&8 == 0 {
+		eights = ^eights
+	}
+	return ones & twos & fours & eights
+}
+
+// ruleCounts : the neighbour counts that give birth to a dead cell and that a live cell survives
+func ruleCounts(rule Rule) (births, survivals []int) {
+	for count := 0; count <= 8; count++ {
+		if rule.Birth[count] {
+			births = append(births, count)
+		}
+		if rule.Survive[count] {
+			survivals = append(survivals, count)
+		}
+	}
+	return
+}
+
+// edgeMasks : a mask for each word of a row clearing the leftmost and rightmost cells and anything past the end of the row
+func edgeMasks(width, words int) []uint64 {
+	masks := make([]uint64, words)
+	for i := range masks {
+		masks[i] = ^uint64(0)
+		if end := width - 1 - i*64; end < 64 {
+			if end <= 0 {
+				masks[i] = 0
+			} else {
+				masks[i] = 1<<uint(end) - 1
+			}
+		}
+	}
+	masks[0] &^= 1
+	return masks
+}
+
+// killRow : makes every cell in next dead, marking the tiles where row had alive cells as changed
+func killRow(row, next []byte, tileRow int, changed []bool) {
+	for i := range next {
+		if changed != nil && row[i] != 0 {
+			changed[tileRow+i/8] = true
+		}
+		next[i] = 0
+	}
+}
@@ -0,0 +1,70 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestNextStateSWAREdges checks the outermost ring is left dead and other rules give births and survivals,
+// comparing a word at a time kernel with counting each cell's neighbours one by one.
+func TestNextStateSWAREdges(t *testing.T) {
+	highLife, _ := ParseRule("B36/S23")
+	for _, width := range []int{3, 63, 64, 65, 130} {
+		world := PackWorld(randomWorld(width, 7), width, 7)
+		next := NewPackedWorld(width, 7)
+		NextStateSWAR(world, next, highLife)
+		for y := 0; y < 7; y++ {
+			for x := 0; x < width; x++ {
+				expected := false
+				if x > 0 && y > 0 && x < width-1 && y < 6 {
+					count := 0
+					for dy := -1; dy <= 1; dy++ {
+						for dx := -1; dx <= 1; dx++ {
+							if (dx != 0 || dy != 0) && world.Alive(x+dx, y+dy) {
+								count++
+							}
+						}
+					}
+					expected = highLife.Birth[count]
+					if world.Alive(x, y) {
+						expected = highLife.Survive[count]
+					}
+				}
+				if next.Alive(x, y) != expected {
+					t.Errorf("width %d cell %d,%d alive is %v, expected %v", width, x, y, next.Alive(x, y), expected)
+				}
+			}
+		}
+	}
+}
+
+// TestNextStateSWARRows checks working out a turn in bands of rows, into a world holding an old turn, matches doing it all at once.
+func TestNextStateSWARRows(t *testing.T) {
+	world := PackWorld(randomWorld(100, 20), 100, 20)
+	whole := NewPackedWorld(100, 20)
+	NextStateSWAR(world, whole, Conway)
+	for _, bands := range [][]int{{0, 1, 20}, {0, 7, 13, 20}, {0, 19, 20}} {
+		banded := PackWorld(randomWorld(100, 20), 100, 20)
+		for i := 0; i < len(bands)-1; i++ {
+			NextStateSWARRows(world, banded, Conway, bands[i], bands[i+1], nil, nil)
+		}
+		if !reflect.DeepEqual(banded, whole) {
+			t.Errorf("bands %v gave a different turn to the whole world", bands)
+		}
+	}
+}
+
+// TestNextStateRows checks working out a turn a cell at a time matches doing it 64 cells at a time.
+func TestNextStateRows(t *testing.T) {
+	highLife, _ := ParseRule("B36/S23")
+	for _, width := range []int{3, 63, 64, 65, 130} {
+		world := PackWorld(randomWorld(width, 20), width, 20)
+		swar := NewPackedWorld(width, 20)
+		NextStateSWAR(world, swar, highLife)
+		cells := NewPackedWorld(width, 20)
+		NextStateRows(world, cells, highLife, 0, 20, nil)
+		if !cells.Equal(swar) {
+			t.Errorf("width %d differs from working it out 64 cells at a time", width)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package util
+
+import "encoding/binary"
+
+// TileSize is the width and height in cells of the tiles a packed world is split into to skip the parts that aren't changing,
+// a tile being one word of a packed row across.
+const TileSize = 64
+
+// TileGrid returns how many rows and columns of tiles cover the world.
+func TileGrid(world PackedWorld) (rows, cols int) {
+	rows = (world.Height + TileSize - 1) / TileSize
+	if world.Height > 0 {
+		cols = len(world.Rows[0]) / 8
+	}
+	return
+}
+
+// ChangedTiles marks the tiles, indexed row-major, with any cell that differs between two worlds of the same size.
+// Tiles not marked in active are taken to be the same, nil active compares every tile.
+func ChangedTiles(old, new PackedWorld, active []bool) []bool {
+	rows, cols := TileGrid(old)
+	changed := make([]bool, rows*cols)
+	for y := 0; y < old.Height; y++ {
+		tileRow := y / TileSize * cols
+		for i := 0; i < cols; i++ {
+			tile := tileRow + i
+			if changed[tile] || (active != nil && !active[tile]) {
+				continue
+			}
+			changed[tile] = binary.LittleEndian.Uint64(old.Rows[y][i*8:]) != binary.LittleEndian.Uint64(new.Rows[y][i*8:])
+		}
+	}
+	return changed
+}
+
+// ActiveTiles marks the tiles next to or on a changed tile, the only ones whose cells can change on the next turn.
+func ActiveTiles(changed []bool, rows, cols int) []bool {
+	active := make([]bool, rows*cols)
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols; x++ {
+			if !changed[y*cols+x] {
+				continue
+			}
+			for ty := y - 1; ty <= y+1; ty++ {
+				for tx := x - 1; tx <= x+1; tx++ {
+					if ty >= 0 && ty < rows && tx >= 0 && tx < cols {
+						active[ty*cols+tx] = true
+					}
+				}
+			}
+		}
+	}
+	return active
+}
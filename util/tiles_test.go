@@ -0,0 +1,46 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestActiveTiles checks only calculating the tiles near ones that changed last turn gives the same turns as calculating them all,
+// on a world that is mostly empty with a soup in one corner and a glider heading across the rest.
+func TestActiveTiles(t *testing.T) {
+	world := NewPackedWorld(640, 448)
+	world.Paste(1, 1, PackWorld(randomWorld(50, 40), 50, 40))
+	for _, cell := range [][2]int{{321, 200}, {322, 201}, {320, 202}, {321, 202}, {322, 202}} {
+		world.Set(cell[0], cell[1], true)
+	}
+	rows, cols := TileGrid(world)
+
+	skipped := world
+	var active []bool
+	for turn := 0; turn < 200; turn++ {
+		whole := NewPackedWorld(640, 448)
+		NextStateSWAR(world, whole, Conway)
+
+		next := NewPackedWorld(640, 448)
+		changed := make([]bool, rows*cols)
+		NextStateSWARRows(skipped, next, Conway, 0, 448, active, changed)
+		if !next.Equal(whole) {
+			t.Fatalf("turn %d differs from calculating every tile", turn+1)
+		}
+		if expected := ChangedTiles(skipped, next, nil); !reflect.DeepEqual(changed, expected) {
+			t.Fatalf("turn %d marked tiles %v as changed, expected %v", turn+1, changed, expected)
+		}
+		active = ActiveTiles(changed, rows, cols)
+		world, skipped = whole, next
+	}
+
+	count := 0
+	for _, tile := range active {
+		if tile {
+			count++
+		}
+	}
+	if count == 0 || count > len(active)/2 {
+		t.Errorf("%d of %d tiles active after 200 turns", count, len(active))
+	}
+}
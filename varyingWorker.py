@@ -3,28 +3,24 @@ import subprocess
 import time
 
 
+# Workers are started with -broker BROKER_ADDRESS so they register themselves,
+# and the broker uses as many of them as -t asks for
 BROKER_ADDRESS = "localhost:8032"
-WORKER_ADDRESSES = ["localhost:8030", "localhost:8031"]
+MAX_WORKERS = 2
+HALO_DEPTHS = [1, 2, 4, 8]
 
 
-def getTime(threads):
-    workerAddresses = ""
-    for i in range(threads):
-        workerAddresses += WORKER_ADDRESSES[i]
-        workerAddresses += ","
-        if i == threads:
-            break
-    workerAddresses=workerAddresses[:-1]
-
+def getTime(threads, haloDepth):
     start_time = time.time()
-    subprocess.call(["go", "run", "main.go", "-t",str(threads), "-brokerAddress", BROKER_ADDRESS, "-workerAddresses", workerAddresses, "-turns","2000"])
+    subprocess.call(["go", "run", ".", "-t",str(threads), "-brokerAddress", BROKER_ADDRESS, "-turns","2000", "-haloDepth", str(haloDepth), "-noVis"])
     return time.time() - start_time
 
 with open('output.csv', 'w', newline='') as file:
     writer = csv.writer(file)
-    for threads in range(1, 3):
-        row = [threads]
-        for _ in range(10):
-            row.append(getTime(threads))
-        writer.writerow(row)
+    for threads in range(1, MAX_WORKERS + 1):
+        for haloDepth in HALO_DEPTHS:
+            row = [threads, haloDepth]
+            for _ in range(10):
+                row.append(getTime(threads, haloDepth))
+            writer.writerow(row)
             
@@ -0,0 +1,1141 @@
+package worker
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+	"uk.ac.bris.cs/gameoflife/checkpoint"
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// Leave : called when signalled, writes this worker's section to checkpointFile if given once any turn in progress is done,
+// then asks the Broker to hand the section to a neighbour. Returns the status to exit with,
+// 1 if the checkpoint couldn't be written or the Broker couldn't take the section, so the world has to be recovered without it
+func Leave(w *Worker, brokerAdr, address, checkpointFile string, timeout time.Duration) int {
+	status := 0
+	w.worldMu.Lock()
+	holding := w.width > 0 && w.height > 0
+	saved := checkpoint.Checkpoint{Turn: w.turn, Width: w.width, Height: w.height, Rule: w.rule.String(), Boundary: w.boundary.String()}
+	if holding {
+		saved.World = section(w)
+	}
+	w.worldMu.Unlock()
+	if holding && checkpointFile != "" {
+		err := checkpoint.Save(checkpointFile, saved)
+		if err != nil {
+			println("Error in Worker saving checkpoint:", err.Error())
+			status = 1
+		} else {
+			println("Section saved at turn", saved.Turn, "to", checkpointFile)
+		}
+	}
+
+	if brokerAdr == "" {
+		//Only a Broker this worker registered with can be told
+		return status
+	}
+	conn, err := net.DialTimeout("tcp", brokerAdr, timeout)
+	if err != nil {
+		println("Error in Worker telling Broker it is leaving:", err.Error())
+		return failedToLeave(holding)
+	}
+	broker := rpc.NewClient(conn)
+	defer broker.Close()
+	call := broker.Go(stubs.BrokerLeave, stubs.RegisterReq{Address: reachableAddress(address, conn)}, &stubs.None{}, nil)
+	select {
+	case <-call.Done:
+		err = call.Error
+	case <-time.After(timeout):
+		err = errors.New("Broker didn't reply in time")
+	}
+	if err != nil {
+		println("Error in Worker telling Broker it is leaving:", err.Error())
+		return failedToLeave(holding)
+	}
+	println("Left Broker at", brokerAdr)
+	return status
+}
+
+// failedToLeave : the status to exit with when the Broker couldn't be told, which only matters if this worker had a section
+func failedToLeave(holding bool) int {
+	if holding {
+		return 1
+	}
+	return 0
+}
+
+// RegisterWithBroker : registers this worker with the Broker then keeps sending it heartbeats,
+// registering again whenever the Broker restarts or forgets about it, until stop is closed
+func RegisterWithBroker(brokerAdr, address string, capacity int, interval time.Duration, stop <-chan struct{}) {
+	var broker *rpc.Client
+	registered := false
+	for first := true; ; first = false {
+		if !first {
+			select {
+			case <-stop:
+				if broker != nil {
+					_ = broker.Close()
+				}
+				return
+			case <-time.After(interval):
+			}
+		}
+		if broker == nil {
+			conn, err := net.Dial("tcp", brokerAdr)
+			if err != nil {
+				continue
+			}
+			broker = rpc.NewClient(conn)
+			address = reachableAddress(address, conn)
+			registered = false
+		}
+
+		req := stubs.RegisterReq{Address: address, Capacity: capacity}
+		var err error
+		if !registered {
+			err = broker.Call(stubs.BrokerRegister, req, &stubs.None{})
+			registered = err == nil
+			if registered {
+				println("Registered with Broker at", brokerAdr, "as", address)
+			}
+		} else {
+			heartbeatRes := stubs.HeartbeatRes{}
+			err = broker.Call(stubs.BrokerHeartbeat, req, &heartbeatRes)
+			registered = err == nil && heartbeatRes.Registered
+		}
+		if err != nil {
+			println("Lost contact with Broker:", err.Error())
+			_ = broker.Close()
+			broker = nil
+		}
+	}
+}
+
+// reachableAddress : the listen address with a host the Broker can reach it on,
+// if it was only given a port it is whatever address this machine reached the Broker from
+func reachableAddress(address string, conn net.Conn) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = conn.LocalAddr().(*net.TCPAddr).IP.String()
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// Settings : how a Worker calculates its section of the world and reaches its neighbours
+type Settings struct {
+	Swar    bool //calculate 64 cells at a time with bitwise adders on the packed world, rather than a cell at a time
+	Threads int  //goroutines to split the section between when the Broker doesn't say how many
+
+	//Dial connects to the neighbouring Worker at an address.
+	//Nil dials over TCP, in process it is whatever the Workers are being served over
+	Dial func(address string) (net.Conn, error)
+}
+
+// New : a Worker with no section of the world yet, ready to be registered with an rpc.Server
+func New(settings Settings) *Worker {
+	return &Worker{swar: settings.Swar, ownThreads: settings.Threads, dial: settings.Dial, killed: make(chan struct{})}
+}
+
+// Killed : closed once Kill has been called, so whatever is serving the Worker can stop
+func Killed(w *Worker) <-chan struct{} {
+	return w.killed
+}
+
+type Worker struct {
+	padded        util.PackedWorld            //this worker's section of the world with depth cells of halo all the way round
+	depth         int                         //rows and columns of halo swapped with neighbours, and so turns between swaps
+	haloTurns     int                         //turns left before the halo runs out and has to be swapped again
+	botHalo       chan stubs.WorkerHaloReqRes //bottom halo rows, sent by the worker below
+	rightHalo     chan stubs.WorkerHaloReqRes //right halo columns, sent by the worker to the right
+	halos         [4]haloCache                //last halos swapped with the neighbour on each side
+	changed       []bool                      //tiles of padded that changed last turn, nil before the first turn
+	haloBefore    []uint64                    //halo words from before the halos were swapped, to see which tiles they changed
+	active        []bool                      //tiles of padded being calculated this turn, nil for all of them
+	worldMu       sync.Mutex
+	turnMu        sync.Mutex //held while taking a turn, so Init can wait for a turn it aborted to give up
+	worldChan     chan calculated
+	swar          bool             //calculate turns with util.NextStateSWAR
+	threads       int              //goroutines each turn is split between
+	ownThreads    int              //threads to use when the Broker doesn't say how many
+	spare         util.PackedWorld //world from the turn before last, reused to calculate the next turn into
+	busy          time.Duration    //time spent calculating turns since the last Report
+	turn          int
+	width         int
+	height        int
+	rule          util.Rule
+	boundary      util.Boundary
+	topEdge       bool //holds the top row of the world
+	bottomEdge    bool //holds the bottom row of the world
+	leftEdge      bool //holds the left column of the world
+	rightEdge     bool //holds the right column of the world
+	spansWidth    bool //holds whole rows of the world, so has no neighbours to the side
+	PrintProgress bool
+	worldBuilt    chan bool //ready to swap columns with the worker to the right
+	sidesBuilt    chan bool //ready to swap rows with the worker below
+	workerAbove   *rpc.Client
+	aboveAdr      string
+	workerLeft    *rpc.Client
+	dial          func(address string) (net.Conn, error)
+
+	epoch int           //incremented by the Broker every time it redistributes the world
+	abort chan struct{} //closed when a new Init arrives, releasing anything still waiting on the old world
+
+	//Running by itself after RunUntil, rather than one turn per Progress
+	running       bool
+	target        int  //turn to stop at
+	recordFlips   bool //report the cells flipped each turn
+	targetChanged chan bool
+	turnDone      chan bool       //signalled after every turn, for Report
+	reports       chan stubs.Turn //turns not yet collected by Report, when recording flips
+	runErr        error           //why running by itself stopped early
+
+	migrateDown chan util.PackedWorld //rows to pass to the worker below during a Rebalance
+	migrateUp   chan util.PackedWorld //rows passed up by the worker below during a Rebalance
+
+	killed   chan struct{} //closed by Kill, so the worker stops listening and exits
+	killOnce sync.Once
+}
+
+// epochChans : the channels Init makes for an epoch, taken together under worldMu
+// so that a turn started before the Broker redistributed the world never touches the ones made for the new world
+type epochChans struct {
+	epoch      int
+	abort      chan struct{}
+	worldChan  chan calculated
+	worldBuilt chan bool
+	sidesBuilt chan bool
+	botHalo    chan stubs.WorkerHaloReqRes
+	rightHalo  chan stubs.WorkerHaloReqRes
+}
+
+// currentChans : the channels for the epoch the worker is on
+func currentChans(w *Worker) epochChans {
+	w.worldMu.Lock()
+	defer w.worldMu.Unlock()
+	return chansLocked(w)
+}
+
+// chansLocked : the channels for the epoch the worker is on, must hold worldMu
+func chansLocked(w *Worker) epochChans {
+	return epochChans{epoch: w.epoch, abort: w.abort, worldChan: w.worldChan, worldBuilt: w.worldBuilt,
+		sidesBuilt: w.sidesBuilt, botHalo: w.botHalo, rightHalo: w.rightHalo}
+}
+
+// calculated : a turn worked out by calculateNextState, with how long it took
+type calculated struct {
+	padded  util.PackedWorld
+	changed []bool //tiles with a cell that changed
+	took    time.Duration
+}
+
+// Sides of a worker's section, indexing Worker.halos
+const (
+	haloAbove = iota
+	haloBelow
+	haloLeft
+	haloRight
+)
+
+// haloCache : the last halo sent to and received from a neighbour, so a halo that hasn't changed can be left out
+type haloCache struct {
+	sent     util.PackedWorld
+	received util.PackedWorld
+}
+
+// send : the halo to send to the neighbour, left out if the neighbour was already sent the same one
+func (c *haloCache) send(halo util.PackedWorld) (util.PackedWorld, bool) {
+	if halo.Equal(c.sent) {
+		return util.PackedWorld{}, true
+	}
+	c.sent = halo
+	return halo, false
+}
+
+// receive : the halo from the neighbour, the one received before if it was left out
+func (c *haloCache) receive(req stubs.WorkerHaloReqRes) (util.PackedWorld, error) {
+	if !req.Same {
+		c.received = req.Halo
+	} else if c.received.Rows == nil {
+		return c.received, errors.New("halo was left out but none has been received before")
+	}
+	return c.received, nil
+}
+
+// maxReports : turns of flips held for the Broker before the worker waits for it to collect them
+const maxReports = 256
+
+var errAborted = errors.New("worker was re-initialised by Broker")
+
+// Init : Called by Broker to first place data inside a worker, and again to restart it after another worker failed
+func (w *Worker) Init(req stubs.WorkerInitReq, res *stubs.None) (err error) {
+	w.worldMu.Lock()
+	if w.abort != nil {
+		select {
+		case <-w.abort: //already closed, the worker left during a scale down
+		default:
+			close(w.abort)
+		}
+	}
+	//Closing the neighbours fails any halo swap still waiting on them
+	if w.workerAbove != nil {
+		_ = w.workerAbove.Close()
+	}
+	if w.workerLeft != nil {
+		_ = w.workerLeft.Close()
+	}
+	w.worldMu.Unlock()
+	//Wait for a turn still being taken to see it was aborted, so it can't touch the world given here
+	w.turnMu.Lock()
+	defer w.turnMu.Unlock()
+	w.worldMu.Lock()
+	w.workerAbove = nil
+	w.workerLeft = nil
+	w.abort = make(chan struct{})
+	w.epoch = req.Epoch
+	w.turn = req.Turn
+	w.width = req.Width
+	w.height = req.Height
+	w.depth = req.HaloDepth
+	if w.depth < 1 {
+		w.depth = 1
+	}
+	w.threads = req.Threads
+	if w.threads < 1 {
+		w.threads = w.ownThreads
+	}
+	println("Worker created, on", w.width, "x", w.height, "with", w.threads, "threads.")
+	setWorld(w, req.World)
+	w.rule = req.Rule
+	w.boundary = req.Boundary
+	w.worldChan = make(chan calculated, 1)
+	w.spare = util.PackedWorld{} //a turn from before may still be being calculated into it
+	w.busy = 0
+	w.worldBuilt = make(chan bool, 1)
+	w.sidesBuilt = make(chan bool, 1)
+	w.botHalo = make(chan stubs.WorkerHaloReqRes, 1)
+	w.rightHalo = make(chan stubs.WorkerHaloReqRes, 1)
+	w.PrintProgress = req.PrintProgress
+	w.running = false
+	w.runErr = nil
+	w.targetChanged = make(chan bool, 1)
+	w.turnDone = make(chan bool, 1)
+	w.reports = make(chan stubs.Turn, maxReports)
+	w.migrateDown = make(chan util.PackedWorld, 1)
+	w.migrateUp = make(chan util.PackedWorld, 1)
+	w.worldMu.Unlock()
+	if w.PrintProgress {
+		println("On Turn", w.turn)
+		util.VisualiseMatrix(section(w).Unpack(), w.width, w.height)
+	}
+	return
+}
+
+// setWorld : pads world with room for the halos and makes it this worker's section, must hold worldMu
+func setWorld(w *Worker, world util.PackedWorld) {
+	w.height = world.Height
+	w.haloTurns = 0
+	w.padded = util.NewPackedWorld(w.width+2*w.depth, w.height+2*w.depth)
+	w.padded.Paste(w.depth, w.depth, world)
+	//Every worker gets a new world at the same time, so neighbours forget the halos they swapped together
+	w.halos = [4]haloCache{}
+	w.changed = nil
+	w.active = nil
+}
+
+// section : copies this worker's section of the world out of the middle of padded, must hold worldMu
+func section(w *Worker) util.PackedWorld {
+	return w.padded.Block(w.depth, w.depth, w.width, w.height)
+}
+
+// Start : Called by Broker once to start communication between workers
+func (w *Worker) Start(req stubs.WorkerStartReq, res *stubs.None) (err error) {
+	w.turnMu.Lock()
+	defer w.turnMu.Unlock()
+	w.topEdge = req.TopEdge
+	w.bottomEdge = req.BottomEdge
+	w.leftEdge = req.LeftEdge
+	w.rightEdge = req.RightEdge
+	w.spansWidth = req.LeftAdr == ""
+	c := currentChans(w)
+	if w.haloFromRight() {
+		c.worldBuilt <- true
+	}
+	//Connect with workers above and to the left
+	err = linkAbove(w, req.AboveAdr)
+	if err != nil {
+		return
+	}
+	if w.callsLeft() {
+		w.workerLeft, err = dialNeighbour(w, req.LeftAdr)
+		if err != nil {
+			println("Error in Worker connecting to Worker: ", err.Error())
+			return errors.New(fmt.Sprint("Error in Worker connecting to Worker: ", err.Error()))
+		}
+	}
+	//Do first communication with neighbouring workers
+	return progressHelper(w, c)
+}
+
+// Progress : Called by Broker to progressHelper the worker one turn
+func (w *Worker) Progress(req stubs.WorkerProgressReq, res *stubs.Turn) (err error) {
+	*res, err = progressTurn(w, currentChans(w), req.Flips)
+	return
+}
+
+// progressTurn : takes the next turn of epoch c once it has been calculated and starts calculating the one after
+func progressTurn(w *Worker, c epochChans, flips bool) (res stubs.Turn, err error) {
+	w.turnMu.Lock()
+	defer w.turnMu.Unlock()
+	//Get world when done calculating
+	var next calculated
+	select {
+	case next = <-c.worldChan:
+	case <-c.abort:
+		return res, errAborted
+	}
+	w.worldMu.Lock()
+	//A turn calculated before Init must not replace the world it was given
+	if w.epoch != c.epoch {
+		w.worldMu.Unlock()
+		return res, errAborted
+	}
+	if flips {
+		res.Flipped = util.EncodeFlips(flippedCells(w.padded, next.padded, w.depth, w.width, w.height))
+	}
+	res.Busy = next.took
+	w.changed = next.changed
+	w.spare = w.padded
+	w.padded = next.padded
+	w.turn++
+	w.busy += next.took
+	w.worldMu.Unlock()
+	if w.haloTurns == 0 && w.haloFromRight() {
+		c.worldBuilt <- true
+	}
+
+	//Send receive neighbours halo/send world to calculate
+	err = progressHelper(w, c)
+	if err != nil {
+		return res, err
+	}
+	res.Turn = w.turn
+	return
+}
+
+// RunUntil : Called by Broker to have the worker carry on by itself until it reaches req.Turn,
+// swapping halos with its neighbours without waiting for the Broker. Called again while running to move the target,
+// a worker already past the new target stops where it is. Replies with the turn the worker is on
+func (w *Worker) RunUntil(req stubs.WorkerRunReq, res *stubs.Turn) (err error) {
+	w.worldMu.Lock()
+	w.target = req.Turn
+	w.recordFlips = req.Flips
+	res.Turn = w.turn
+	start := !w.running
+	w.running = true
+	c := chansLocked(w)
+	targetChanged, turnDone, reports := w.targetChanged, w.turnDone, w.reports
+	w.worldMu.Unlock()
+	if start {
+		go runLoop(w, c, targetChanged, turnDone, reports)
+	} else {
+		select {
+		case targetChanged <- true:
+		default: //already told
+		}
+	}
+	return
+}
+
+// runLoop : calculates turns of epoch c until the target turn is reached, then waits for RunUntil to move the target.
+// Stops once Init starts another epoch, which starts its own runLoop when RunUntil is called again
+func runLoop(w *Worker, c epochChans, targetChanged, turnDone chan bool, reports chan stubs.Turn) {
+	for {
+		w.worldMu.Lock()
+		if w.epoch != c.epoch {
+			w.worldMu.Unlock()
+			return
+		}
+		reached := w.turn >= w.target
+		flips := w.recordFlips
+		w.worldMu.Unlock()
+		if reached {
+			select {
+			case <-targetChanged:
+			case <-c.abort:
+				return
+			}
+			continue
+		}
+
+		turn, err := progressTurn(w, c, flips)
+		if err != nil {
+			if err != errAborted {
+				println("Error in Worker running by itself:", err.Error())
+			}
+			w.worldMu.Lock()
+			if w.epoch == c.epoch {
+				w.runErr = err
+			}
+			w.worldMu.Unlock()
+		} else if flips {
+			//Hold on to the flips until the Broker collects them, waiting for it if it has fallen behind
+			select {
+			case reports <- turn:
+			case <-c.abort:
+				return
+			}
+		}
+		select {
+		case turnDone <- true:
+		default: //Report hasn't picked up the last one yet
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Report : Called repeatedly by Broker while the worker runs by itself, waits briefly for a turn to finish
+// then replies with the turn the worker is on and the flips of every turn since the last Report.
+// Without flips to pass on it waits for the target turn instead, so the Broker isn't asking every turn
+func (w *Worker) Report(req stubs.None, res *stubs.WorkerReportRes) (err error) {
+	w.worldMu.Lock()
+	abort := w.abort
+	turnDone := w.turnDone
+	reports := w.reports
+	reached := w.turn >= w.target
+	flips := w.recordFlips
+	w.worldMu.Unlock()
+
+	//No point waiting for a turn that isn't coming
+	timeout := time.After(time.Second)
+	for waiting := !reached; waiting; {
+		select {
+		case turn := <-reports:
+			res.Turns = append(res.Turns, turn)
+			waiting = false
+		case <-turnDone:
+			w.worldMu.Lock()
+			waiting = !flips && w.turn < w.target && w.runErr == nil
+			w.worldMu.Unlock()
+		case <-abort:
+			return errAborted
+		case <-timeout:
+			waiting = false
+		}
+	}
+	//Take whatever else is already waiting
+	for waiting := true; waiting; {
+		select {
+		case turn := <-reports:
+			res.Turns = append(res.Turns, turn)
+		default:
+			waiting = false
+		}
+	}
+
+	w.worldMu.Lock()
+	defer w.worldMu.Unlock()
+	res.Turn = w.turn
+	res.Busy = w.busy
+	w.busy = 0
+	return w.runErr
+}
+
+// Rebalance : Called by Broker between turns to move the top and bottom of this worker's section,
+// passing rows to and from the workers above and below before swapping halos again.
+// The turn already being calculated is thrown away, as its halos were for the old sections.
+// Also used to splice a new worker in between two others, and to drain a worker that is being removed
+func (w *Worker) Rebalance(req stubs.WorkerRebalanceReq, res *stubs.None) (err error) {
+	w.turnMu.Lock()
+	defer w.turnMu.Unlock()
+	c := currentChans(w)
+	if req.Join {
+		//A new worker takes its rows from the worker above, so has to connect to it first
+		w.topEdge = req.TopEdge
+		w.bottomEdge = req.BottomEdge
+		w.spansWidth = true
+		err = linkAbove(w, req.AboveAdr)
+		if err != nil {
+			return
+		}
+	} else {
+		select {
+		case <-c.worldChan:
+		case <-c.abort:
+			return errAborted
+		}
+	}
+
+	//Top > 0 gives rows to the worker above, Top < 0 takes them. Bottom > 0 takes rows from the worker below, Bottom < 0 gives them
+	keepFrom, keepTo := 0, w.height
+	var giveUp util.PackedWorld
+	if req.Top > 0 {
+		giveUp = w.padded.Block(w.depth, w.depth, w.width, req.Top)
+		keepFrom = req.Top
+	}
+	if req.Bottom != 0 {
+		var giveDown util.PackedWorld
+		if req.Bottom < 0 {
+			giveDown = w.padded.Block(w.depth, w.depth+w.height+req.Bottom, w.width, -req.Bottom)
+			keepTo = w.height + req.Bottom
+		}
+		w.migrateDown <- giveDown
+	}
+
+	var fromAbove util.PackedWorld
+	if req.Top != 0 {
+		migrateRes := stubs.WorkerHaloReqRes{}
+		err = w.workerAbove.Call(stubs.WorkerMigrate, stubs.WorkerHaloReqRes{Halo: giveUp, Epoch: w.epoch}, &migrateRes)
+		if err != nil {
+			println("Error doing Migrate", err.Error())
+			return errors.New(fmt.Sprint("Error in Worker calling Migrate on Worker: ", err.Error()))
+		}
+		fromAbove = migrateRes.Halo
+	}
+	var fromBelow util.PackedWorld
+	if req.Bottom != 0 {
+		select {
+		case fromBelow = <-w.migrateUp:
+		case <-c.abort:
+			return errAborted
+		}
+	}
+
+	if req.Leave {
+		println("Worker removed by Broker.")
+		w.worldMu.Lock()
+		close(w.abort)
+		if w.workerAbove != nil {
+			_ = w.workerAbove.Close()
+			w.workerAbove = nil
+		}
+		w.worldMu.Unlock()
+		return
+	}
+
+	//Rows have moved using the old neighbours, now the workers either side may have changed
+	w.topEdge = req.TopEdge
+	w.bottomEdge = req.BottomEdge
+	err = linkAbove(w, req.AboveAdr)
+	if err != nil {
+		return
+	}
+
+	w.worldMu.Lock()
+	kept := w.padded.Block(w.depth, w.depth+keepFrom, w.width, keepTo-keepFrom)
+	rows := append(append(fromAbove.Rows, kept.Rows...), fromBelow.Rows...)
+	setWorld(w, util.PackedWorld{Width: w.width, Height: len(rows), Rows: rows})
+	w.worldMu.Unlock()
+	if w.haloFromRight() {
+		c.worldBuilt <- true
+	}
+	return progressHelper(w, c)
+}
+
+// Migrate : Called by the Worker below during a Rebalance to swap the rows moving between them
+func (w *Worker) Migrate(req stubs.WorkerHaloReqRes, res *stubs.WorkerHaloReqRes) (err error) {
+	if req.Epoch != w.epoch {
+		return errors.New(fmt.Sprint("Migrate from epoch ", req.Epoch, " but Worker is on epoch ", w.epoch))
+	}
+	select {
+	case res.Halo = <-w.migrateDown:
+	case <-w.abort:
+		return errAborted
+	}
+	w.migrateUp <- req.Halo
+	return
+}
+
+// linkAbove : connects to the worker above at aboveAdr, if this worker swaps rows with one
+func linkAbove(w *Worker, aboveAdr string) (err error) {
+	if w.workerAbove != nil && aboveAdr == w.aboveAdr && w.callsAbove() {
+		return
+	}
+	if w.workerAbove != nil {
+		_ = w.workerAbove.Close()
+		w.workerAbove = nil
+	}
+	w.aboveAdr = aboveAdr
+	if w.callsAbove() {
+		w.workerAbove, err = dialNeighbour(w, aboveAdr)
+		if err != nil {
+			println("Error in Worker connecting to Worker: ", err.Error())
+			return errors.New(fmt.Sprint("Error in Worker connecting to Worker: ", err.Error()))
+		}
+	}
+	return
+}
+
+// dialNeighbour : an RPC client for the neighbouring worker at address, over TCP unless the worker was given another way to reach it
+func dialNeighbour(w *Worker, address string) (*rpc.Client, error) {
+	if w.dial == nil {
+		return rpc.Dial("tcp", address)
+	}
+	conn, err := w.dial(address)
+	if err != nil {
+		return nil, err
+	}
+	return rpc.NewClient(conn), nil
+}
+
+// callsAbove : whether this worker swaps rows with a worker above,
+// the top of a world that doesn't wrap has nobody above it
+func (w *Worker) callsAbove() bool {
+	return !w.topEdge || w.boundary.WrapsVertically()
+}
+
+// haloFromBelow : whether a worker below will call Halo on this worker
+func (w *Worker) haloFromBelow() bool {
+	return !w.bottomEdge || w.boundary.WrapsVertically()
+}
+
+// callsLeft : whether this worker swaps columns with a worker to the left
+func (w *Worker) callsLeft() bool {
+	return !w.spansWidth && (!w.leftEdge || w.boundary.WrapsHorizontally())
+}
+
+// haloFromRight : whether a worker to the right will call SideHalo on this worker
+func (w *Worker) haloFromRight() bool {
+	return !w.spansWidth && (!w.rightEdge || w.boundary.WrapsHorizontally())
+}
+
+// progressHelper : swaps halos with the neighbours if they have run out, then starts calculating the next turn of epoch c
+func progressHelper(w *Worker, c epochChans) (err error) {
+	//Only swap halos once the last ones have run out, each turn calculated eats one cell into them
+	exchange := w.haloTurns == 0
+	//Anything the halos change has to be calculated, so remember what they were
+	w.haloBefore = haloWords(w, w.haloBefore)
+	//Swap columns first, so the rows swapped afterwards can carry the corners with them
+	if exchange {
+		err = exchangeColumns(w, c)
+		if err != nil {
+			return err
+		}
+	}
+	fillSideHalos(w)
+	if exchange {
+		if w.haloFromBelow() {
+			c.sidesBuilt <- true
+		}
+		err = exchangeRows(w, c)
+		if err != nil {
+			return err
+		}
+		w.haloTurns = w.depth
+	}
+	fillEdgeHalos(w)
+	w.haloTurns--
+
+	//Only calculate the tiles next to ones that changed, the others are the same as last turn
+	var active []bool
+	if w.changed != nil {
+		markHaloChanges(w, w.haloBefore)
+		rows, cols := util.TileGrid(w.padded)
+		active = util.ActiveTiles(w.changed, rows, cols)
+	}
+	w.worldMu.Lock()
+	defer w.worldMu.Unlock()
+	//Init may have replaced the world while the halos were being swapped
+	if w.epoch != c.epoch {
+		return errAborted
+	}
+	w.active = active
+
+	//Start calculating first turn
+	var next util.PackedWorld
+	if w.swar {
+		//Calculate into the world from the turn before, rather than making a new one every turn
+		if w.spare.Width != w.padded.Width || w.spare.Height != w.padded.Height {
+			w.spare = util.NewPackedWorld(w.padded.Width, w.padded.Height)
+		}
+		next = w.spare
+	} else {
+		//in go slices are initialized to zero, so every cell starts dead
+		next = util.NewPackedWorld(w.padded.Width, w.padded.Height)
+	}
+	go calculateNextState(w.padded, next, active, c.worldChan, w.turn, w.threads, w.rule, w.swar, w.PrintProgress)
+	return
+}
+
+// haloWords : copies every word of padded holding halo cells into words, reusing it if big enough, always in the same order
+func haloWords(w *Worker, words []uint64) []uint64 {
+	words = words[:0]
+	if w.changed == nil {
+		return words
+	}
+	eachHaloSpan(w, func(y, from, to int) {
+		for i := from; i < to; i++ {
+			words = append(words, binary.LittleEndian.Uint64(w.padded.Rows[y][i*8:]))
+		}
+	})
+	return words
+}
+
+// markHaloChanges : marks the tiles where swapping or filling in the halos changed a cell,
+// before being the halo words from haloWords beforehand
+func markHaloChanges(w *Worker, before []uint64) {
+	_, cols := util.TileGrid(w.padded)
+	j := 0
+	eachHaloSpan(w, func(y, from, to int) {
+		for i := from; i < to; i++ {
+			if binary.LittleEndian.Uint64(w.padded.Rows[y][i*8:]) != before[j] {
+				w.changed[y/util.TileSize*cols+i] = true
+			}
+			j++
+		}
+	})
+}
+
+// eachHaloSpan : calls f with each run of words from up to to in row y of padded that hold halo cells
+func eachHaloSpan(w *Worker, f func(y, from, to int)) {
+	d := w.depth
+	words := len(w.padded.Rows[0]) / 8
+	for y := 0; y < w.padded.Height; y++ {
+		if y < d || y >= d+w.height {
+			f(y, 0, words)
+			continue
+		}
+		//Halo columns either side, 64 cells to a word
+		f(y, 0, (d+63)/64)
+		f(y, (d+w.width)/64, words)
+	}
+}
+
+// exchangeColumns : swaps the columns either side of this worker's section of the world with its neighbours
+func exchangeColumns(w *Worker, c epochChans) (err error) {
+	d := w.depth
+	//Share+Get halo columns w neighbour to the left
+	if w.callsLeft() {
+		leftHaloReq := stubs.WorkerHaloReqRes{Epoch: c.epoch}
+		leftHaloReq.Halo, leftHaloReq.Same = w.halos[haloLeft].send(w.padded.Block(d, d, d, w.height))
+		leftHaloRes := stubs.WorkerHaloReqRes{}
+		err = w.workerLeft.Call(stubs.WorkerSideHalo, leftHaloReq, &leftHaloRes)
+		if err != nil {
+			println("Error doing SideHalo exchange", err.Error())
+			return errors.New(fmt.Sprint("Error in Worker calling SideHalo on Worker: ", err.Error()))
+		}
+		leftHalo, err := w.halos[haloLeft].receive(leftHaloRes)
+		if err != nil {
+			return err
+		}
+		w.worldMu.Lock()
+		w.padded.Paste(0, d, leftHalo)
+		w.worldMu.Unlock()
+	}
+
+	//Ensure we have received halo columns from neighbour to the right
+	if w.haloFromRight() {
+		var rightHaloReq stubs.WorkerHaloReqRes
+		select {
+		case rightHaloReq = <-c.rightHalo:
+		case <-c.abort:
+			return errAborted
+		}
+		rightHalo, err := w.halos[haloRight].receive(rightHaloReq)
+		if err != nil {
+			return err
+		}
+		w.worldMu.Lock()
+		w.padded.Paste(d+w.width, d, rightHalo)
+		w.worldMu.Unlock()
+	}
+	return
+}
+
+// exchangeRows : swaps the rows above and below this worker's section of the world with its neighbours,
+// the rows include the side halos so the corners are swapped too
+func exchangeRows(w *Worker, c epochChans) (err error) {
+	d := w.depth
+	//Share+Get halo region w neighbour above
+	if w.callsAbove() {
+		//Wrapping round a klein bottle flips the world left to right, so flip the rows going each way
+		flip := w.topEdge && w.boundary == util.KleinBottle
+		sent := w.padded.Block(0, d, w.width+2*d, d)
+		if flip {
+			sent.Mirror()
+		}
+		topHaloReq := stubs.WorkerHaloReqRes{Epoch: c.epoch}
+		topHaloReq.Halo, topHaloReq.Same = w.halos[haloAbove].send(sent)
+		topHaloRes := stubs.WorkerHaloReqRes{}
+		err = w.workerAbove.Call(stubs.WorkerHalo, topHaloReq, &topHaloRes)
+		if err != nil {
+			println("Error doing Halo exchange", err.Error())
+			return errors.New(fmt.Sprint("Error in Worker calling Halo on Worker: ", err.Error()))
+		}
+		topHalo, err := w.halos[haloAbove].receive(topHaloRes)
+		if err != nil {
+			return err
+		}
+		if flip {
+			//Mirror a copy, the halo received is kept as it was sent
+			topHalo = topHalo.Block(0, 0, topHalo.Width, topHalo.Height)
+			topHalo.Mirror()
+		}
+		w.worldMu.Lock()
+		w.padded.Paste(0, 0, topHalo)
+		w.worldMu.Unlock()
+	}
+
+	//Ensure we have received halo region from neighbour below
+	if w.haloFromBelow() {
+		var botHaloReq stubs.WorkerHaloReqRes
+		select {
+		case botHaloReq = <-c.botHalo:
+		case <-c.abort:
+			return errAborted
+		}
+		botHalo, err := w.halos[haloBelow].receive(botHaloReq)
+		if err != nil {
+			return err
+		}
+		w.worldMu.Lock()
+		w.padded.Paste(0, d+w.height, botHalo)
+		w.worldMu.Unlock()
+	}
+	return
+}
+
+// fillSideHalos : makes the halo columns on the sides this worker doesn't swap with a neighbour,
+// either from its own columns if it holds whole rows of a world that wraps, or from the edge of a world that doesn't
+func fillSideHalos(w *Worker) {
+	wraps := w.spansWidth && w.boundary.WrapsHorizontally()
+	w.worldMu.Lock()
+	defer w.worldMu.Unlock()
+	if !w.callsLeft() {
+		util.FillSideHalo(w.padded, w.depth, w.boundary, wraps, true)
+	}
+	if !w.haloFromRight() {
+		util.FillSideHalo(w.padded, w.depth, w.boundary, wraps, false)
+	}
+}
+
+// fillEdgeHalos : makes the halo rows beyond the top and bottom of a world that doesn't wrap
+func fillEdgeHalos(w *Worker) {
+	w.worldMu.Lock()
+	defer w.worldMu.Unlock()
+	if !w.callsAbove() {
+		util.FillEdgeHalo(w.padded, w.depth, w.boundary, true)
+	}
+	if !w.haloFromBelow() {
+		util.FillEdgeHalo(w.padded, w.depth, w.boundary, false)
+	}
+}
+
+// Halo : Called by below neighbour Worker to exchange halo regions.
+// each worker should call this on their neighbour above and have it called on them by there neighbour below,
+// rows are sent with the side halos on them so that the corners are exchanged too
+func (w *Worker) Halo(req stubs.WorkerHaloReqRes, res *stubs.WorkerHaloReqRes) (err error) {
+	//Init replaces all of these, and a halo left over from before the Broker redistributed the world can arrive while it does
+	w.worldMu.Lock()
+	epoch, sidesBuilt, abort, botHalo := w.epoch, w.sidesBuilt, w.abort, w.botHalo
+	w.worldMu.Unlock()
+	//Reject halos left over from before the Broker redistributed the world
+	if req.Epoch != epoch {
+		return errors.New(fmt.Sprint("Halo from epoch ", req.Epoch, " but Worker is on epoch ", epoch))
+	}
+	select {
+	case <-sidesBuilt:
+	case <-abort:
+		return errAborted
+	}
+	//Receive top from Worker below
+	select {
+	case botHalo <- req:
+	case <-abort:
+		return errAborted
+	}
+	//Send bottom of this worker to Worker below, unless Init has given it another world in the meantime
+	w.worldMu.Lock()
+	defer w.worldMu.Unlock()
+	if w.epoch != epoch {
+		return errAborted
+	}
+	res.Halo, res.Same = w.halos[haloBelow].send(w.padded.Block(0, w.height, w.width+2*w.depth, w.depth))
+	return
+}
+
+// SideHalo : Called by the Worker to the right to exchange halo columns,
+// each worker calls this on their neighbour to the left before exchanging rows with Halo
+func (w *Worker) SideHalo(req stubs.WorkerHaloReqRes, res *stubs.WorkerHaloReqRes) (err error) {
+	w.worldMu.Lock()
+	epoch, worldBuilt, abort, rightHalo := w.epoch, w.worldBuilt, w.abort, w.rightHalo
+	w.worldMu.Unlock()
+	if req.Epoch != epoch {
+		return errors.New(fmt.Sprint("SideHalo from epoch ", req.Epoch, " but Worker is on epoch ", epoch))
+	}
+	select {
+	case <-worldBuilt:
+	case <-abort:
+		return errAborted
+	}
+	//Receive left columns from Worker to the right
+	select {
+	case rightHalo <- req:
+	case <-abort:
+		return errAborted
+	}
+	//Send right columns of this worker back
+	w.worldMu.Lock()
+	defer w.worldMu.Unlock()
+	if w.epoch != epoch {
+		return errAborted
+	}
+	res.Halo, res.Same = w.halos[haloRight].send(w.padded.Block(w.width, w.depth, w.depth, w.height))
+	return
+}
+
+// Count : Called by Broker to count alive cells in worker
+func (w *Worker) Count(req stubs.None, res *stubs.CountCellRes) (err error) {
+	w.worldMu.Lock()
+	res.Count = section(w).Count()
+	res.Active = activeCells(w)
+	res.Turn = w.turn
+	w.worldMu.Unlock()
+	return
+}
+
+// activeCells : how many cells of this worker's section are in tiles being calculated this turn, must hold worldMu
+func activeCells(w *Worker) int {
+	if w.active == nil {
+		return w.width * w.height
+	}
+	d := w.depth
+	_, cols := util.TileGrid(w.padded)
+	cells := 0
+	for tile, active := range w.active {
+		if !active {
+			continue
+		}
+		//Only count the part of the tile inside the section, not the halo
+		left, top := tile%cols*util.TileSize, tile/cols*util.TileSize
+		right, bottom := left+util.TileSize, top+util.TileSize
+		if left < d {
+			left = d
+		}
+		if top < d {
+			top = d
+		}
+		if right > d+w.width {
+			right = d + w.width
+		}
+		if bottom > d+w.height {
+			bottom = d + w.height
+		}
+		if right > left && bottom > top {
+			cells += (right - left) * (bottom - top)
+		}
+	}
+	return cells
+}
+
+// Fetch : Called by Broker to get the world stored in worker
+func (w *Worker) Fetch(req stubs.None, res *stubs.WorldRes) (err error) {
+	w.worldMu.Lock()
+	res.World = section(w)
+	res.Turn = w.turn
+	w.worldMu.Unlock()
+	return
+}
+
+// Ping : Called by Broker to check the worker is still alive
+func (w *Worker) Ping(req stubs.None, res *stubs.None) (err error) {
+	return
+}
+
+func (w *Worker) Quit(req stubs.None, res *stubs.None) (err error) {
+	println("Worker quit.")
+	w.turn = -1
+	return
+}
+
+// Kill : Called by the Broker to have this worker exit, which it does once the reply has been sent and the Broker has hung up
+func (w *Worker) Kill(req stubs.None, res *stubs.None) (err error) {
+	println("Worker killed.")
+	//Hang up on the neighbours, which are waiting for that to exit too.
+	//A turn may still be calling them, which the closed clients fail rather than leaving it nothing to call
+	w.worldMu.Lock()
+	if w.workerAbove != nil {
+		_ = w.workerAbove.Close()
+	}
+	if w.workerLeft != nil {
+		_ = w.workerLeft.Close()
+	}
+	w.worldMu.Unlock()
+	w.killOnce.Do(func() {
+		close(w.killed)
+	})
+	return
+}
+
+// flippedCells : row-major indices of every cell that differs between the width x height sections
+// in the middle of two worlds padded by depth cells
+func flippedCells(oldWorld, newWorld util.PackedWorld, depth, width, height int) []int {
+	var flipped []int
+	for y := 0; y < height; y++ {
+		oldRow, newRow := oldWorld.Rows[depth+y], newWorld.Rows[depth+y]
+		//Compare 8 cells at a time, only looking at the cells of bytes that differ
+		for i := range oldRow {
+			for diff := oldRow[i] ^ newRow[i]; diff != 0; diff &= diff - 1 {
+				x := i*8 + bits.TrailingZeros8(diff) - depth
+				if x >= 0 && x < width {
+					flipped = append(flipped, y*width+x)
+				}
+			}
+		}
+	}
+	return flipped
+}
+
+// calculateNextState : works out the next turn of world into next, split between threads goroutines
+// using indexing x,y where 0,0 is top left of board,
+// world is padded with halo so only the outermost ring of cells is missing neighbours, and is left dead
+func calculateNextState(world, next util.PackedWorld, active []bool, worldChan chan<- calculated, turn, threads int, rule util.Rule, swar, printProgress bool) {
+	start := time.Now()
+	if printProgress {
+		println("On Turn", turn, "with halo:")
+		util.VisualiseMatrix(world.Unpack(), world.Width, world.Height)
+	}
+
+	//Split the rows into a band for each thread, each writing only to its own rows of next
+	if threads > world.Height {
+		threads = world.Height
+	}
+	//Bands can share a row of tiles, so each marks the tiles it changed separately
+	rows, cols := util.TileGrid(world)
+	changed := make([][]bool, threads)
+	var wg sync.WaitGroup
+	for i := 0; i < threads; i++ {
+		startY, endY := i*world.Height/threads, (i+1)*world.Height/threads
+		changed[i] = make([]bool, rows*cols)
+		wg.Add(1)
+		go func(changed []bool) {
+			defer wg.Done()
+			if swar {
+				util.NextStateSWARRows(world, next, rule, startY, endY, active, changed)
+			} else {
+				util.NextStateRows(world, next, rule, startY, endY, active)
+			}
+		}(changed[i])
+	}
+	wg.Wait()
+
+	if !swar {
+		changed[0] = util.ChangedTiles(world, next, active)
+	}
+	for _, band := range changed[1:] {
+		for tile, bandChanged := range band {
+			changed[0][tile] = changed[0][tile] || bandChanged
+		}
+	}
+	worldChan <- calculated{next, changed[0], time.Since(start)}
+}
@@ -0,0 +1,181 @@
+package worker_test
+
+import (
+	"fmt"
+	"net/rpc"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/internal/pipenet"
+	"uk.ac.bris.cs/gameoflife/internal/testutil"
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/util"
+	"uk.ac.bris.cs/gameoflife/worker"
+)
+
+// startBands serves two Workers over pipes and gives each half the rows of world on a torus, as the Broker would,
+// returning a client for each.
+func startBands(t *testing.T, network *pipenet.Network, world util.PackedWorld, epoch, depth int) []*rpc.Client {
+	var workers []*rpc.Client
+	for i := 0; i < 2; i++ {
+		address := fmt.Sprint("worker", i+1)
+		if epoch == 1 {
+			err := network.Serve(address, worker.New(worker.Settings{Swar: true, Threads: 1, Dial: network.Dial}))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		conn, err := network.Dial(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		workers = append(workers, rpc.NewClient(conn))
+	}
+
+	half := world.Height / 2
+	for i, w := range workers {
+		initReq := stubs.WorkerInitReq{World: world.Block(0, i*half, world.Width, half), Width: world.Width, Height: half,
+			Epoch: epoch, Rule: util.Conway, HaloDepth: depth}
+		err := w.Call(stubs.WorkerInit, initReq, &stubs.None{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	//Each worker waits on the other to swap its first halos
+	starts := []stubs.WorkerStartReq{
+		{AboveAdr: "worker2", TopEdge: true, LeftEdge: true, RightEdge: true},
+		{AboveAdr: "worker1", BottomEdge: true, LeftEdge: true, RightEdge: true},
+	}
+	var calls []*rpc.Call
+	for i, w := range workers {
+		calls = append(calls, w.Go(stubs.WorkerStart, starts[i], &stubs.None{}, nil))
+	}
+	for _, call := range calls {
+		<-call.Done
+		if call.Error != nil {
+			t.Fatal(call.Error)
+		}
+	}
+	return workers
+}
+
+// runUntil has both workers run their halves of a 64x64 world by themselves to turn, reporting until they get there,
+// and returns the world they end up with and the flips of every turn reported on the way if flips is set.
+func runUntil(t *testing.T, workers []*rpc.Client, turn int, flips bool) (util.PackedWorld, [][]int) {
+	//Neither can get far without the other, so both have to be set going before waiting on either
+	for _, w := range workers {
+		err := w.Call(stubs.WorkerRunUntil, stubs.WorkerRunReq{Turn: turn, Flips: flips}, &stubs.Turn{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var turnFlips [][]int
+	for i, w := range workers {
+		for reached := 0; reached < turn; {
+			report := stubs.WorkerReportRes{}
+			err := w.Call(stubs.WorkerReport, stubs.None{}, &report)
+			if err != nil {
+				t.Fatal(err)
+			}
+			//A turn's flips can be reported after the turn, so while recording them only they say how far the worker has got
+			if !flips {
+				reached = report.Turn
+			}
+			for _, reported := range report.Turns {
+				reached = reported.Turn
+				//Each worker's flips are in its own section, the second one's start half way down
+				for len(turnFlips) < reported.Turn {
+					turnFlips = append(turnFlips, nil)
+				}
+				for _, index := range util.DecodeFlips(reported.Flipped) {
+					turnFlips[reported.Turn-1] = append(turnFlips[reported.Turn-1], index+i*32*64)
+				}
+			}
+		}
+	}
+
+	world := util.NewPackedWorld(64, 64)
+	for i, w := range workers {
+		res := stubs.WorldRes{}
+		err := w.Call(stubs.WorkerFetch, stubs.None{}, &res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Turn != turn {
+			t.Errorf("worker%d stopped on turn %d, expected %d", i+1, res.Turn, turn)
+		}
+		world.Paste(0, i*32, res.World)
+	}
+	return world, turnFlips
+}
+
+// TestRunUntil runs two Workers by themselves to a turn while recording flips, then on to the end without,
+// and checks moving the target back leaves them where they are.
+func TestRunUntil(t *testing.T) {
+	world := testutil.ReadFixture(t, "64x64x0")
+	for _, depth := range []int{1, 3} {
+		t.Run(fmt.Sprintf("depth%d", depth), func(t *testing.T) {
+			network := pipenet.New()
+			defer network.Close()
+			workers := startBands(t, network, world, 1, depth)
+
+			reached, turnFlips := runUntil(t, workers, 30, true)
+			expected := world
+			followed := world
+			for turn := 1; turn <= 30; turn++ {
+				expected = testutil.NextTorus(expected, util.Conway)
+				followed = followed.Block(0, 0, 64, 64)
+				for _, index := range turnFlips[turn-1] {
+					followed.Set(index%64, index/64, !followed.Alive(index%64, index/64))
+				}
+				if !followed.Equal(expected) {
+					t.Fatalf("flips reported for turn %d don't give the reference world", turn)
+				}
+			}
+			if !reached.Equal(expected) {
+				t.Error("world on turn 30 differs from the reference")
+			}
+
+			reached, _ = runUntil(t, workers, 100, false)
+			if !reached.Equal(testutil.ReadFixture(t, "64x64x100")) {
+				t.Error("world on turn 100 differs from the expected image")
+			}
+			for i, w := range workers {
+				res := stubs.Turn{}
+				err := w.Call(stubs.WorkerRunUntil, stubs.WorkerRunReq{Turn: 50}, &res)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if res.Turn != 100 {
+					t.Errorf("worker%d was on turn %d when its target was moved back, expected 100", i+1, res.Turn)
+				}
+			}
+		})
+	}
+}
+
+// TestRunUntilInitAgain gives two Workers running by themselves a new world part way through, as the Broker does
+// to recover from another Worker dying. Nothing from the old world may leak into the new one.
+func TestRunUntilInitAgain(t *testing.T) {
+	world := testutil.ReadFixture(t, "64x64x0")
+	expected := testutil.ReadFixture(t, "64x64x100")
+	for _, depth := range []int{1, 3} {
+		t.Run(fmt.Sprintf("depth%d", depth), func(t *testing.T) {
+			network := pipenet.New()
+			defer network.Close()
+			workers := startBands(t, network, world, 1, depth)
+			for _, w := range workers {
+				err := w.Call(stubs.WorkerRunUntil, stubs.WorkerRunReq{Turn: 1 << 30}, &stubs.Turn{})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			for epoch := 2; epoch < 10; epoch++ {
+				workers = startBands(t, network, world, epoch, depth)
+			}
+			reached, _ := runUntil(t, workers, 100, false)
+			if !reached.Equal(expected) {
+				t.Error("world on turn 100 differs from the expected image")
+			}
+		})
+	}
+}